@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"github.com/go-ozzo/ozzo-routing"
+	"strings"
+	"time"
+)
+
+// API key roles. Admin can call every endpoint, client can't manage
+// players, tournaments and keys.
+const (
+	RoleAdmin  = "admin"
+	RoleClient = "client"
+)
+
+// Structure (Model) for API key. Key itself is shown only once when issued,
+// database keeps sha256 of it.
+type APIKey struct {
+	ID        string     `db:"id" json:"id"`
+	Hash      string     `db:"hash" json:"-"`
+	Role      string     `db:"role" json:"role"`
+	Name      string     `db:"name" json:"name"`
+	CreatedAt time.Time  `db:"created_at" json:"createdAt"`
+	RevokedAt *time.Time `db:"revoked_at" json:"revokedAt,omitempty"`
+}
+
+// Structure for issued API key response
+type IssuedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Hash of API key stored in database
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Random hex string of n bytes
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Method for issue new API key with role, key is "<id>.<secret>"
+func (service *Service) IssueAPIKey(role string, name string) (IssuedAPIKey, error) {
+	if role != RoleAdmin && role != RoleClient {
+		return IssuedAPIKey{}, ErrInvalidRole
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return IssuedAPIKey{}, err
+	}
+	secret, err := randomHex(24)
+	if err != nil {
+		return IssuedAPIKey{}, err
+	}
+
+	issued := IssuedAPIKey{Key: id + "." + secret}
+	issued.APIKey = APIKey{
+		ID:        id,
+		Hash:      hashAPIKey(issued.Key),
+		Role:      role,
+		Name:      name,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+
+	err = service.store.Transactional(func(tx StoreTx) error {
+		return tx.InsertAPIKey(issued.APIKey)
+	})
+	if err != nil {
+		service.Logger.Error("database error", "error", err, "apiKeyId", issued.ID)
+		return IssuedAPIKey{}, err
+	}
+	return issued, nil
+}
+
+// Method for list API keys, revoked keys included
+func (service *Service) APIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := service.store.Transactional(func(tx StoreTx) error {
+		var err error
+		keys, err = tx.APIKeys()
+		return err
+	})
+	if err != nil {
+		service.Logger.Error("database error", "error", err)
+	}
+	return keys, err
+}
+
+// Method for revoke API key, revoking revoked key does nothing
+func (service *Service) RevokeAPIKey(id string) error {
+	return service.store.Transactional(func(tx StoreTx) error {
+		key, err := tx.APIKey(id)
+		if err == sql.ErrNoRows {
+			return ErrAPIKeyNotFound
+		}
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "apiKeyId", id)
+			return err
+		}
+		if key.RevokedAt != nil {
+			return nil
+		}
+
+		err = tx.RevokeAPIKey(id, time.Now().UTC())
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "apiKeyId", id)
+		}
+		return err
+	})
+}
+
+// Method for find role of API key, AdminKey of service is accepted as admin key
+func (service *Service) Authenticate(key string) (string, error) {
+	if service.AdminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(service.AdminKey)) == 1 {
+		return RoleAdmin, nil
+	}
+
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrUnauthorized
+	}
+
+	var role string
+	err := service.store.Transactional(func(tx StoreTx) error {
+		stored, err := tx.APIKey(parts[0])
+		if err == sql.ErrNoRows {
+			return ErrUnauthorized
+		}
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "apiKeyId", parts[0])
+			return err
+		}
+
+		if stored.RevokedAt != nil || subtle.ConstantTimeCompare([]byte(hashAPIKey(key)), []byte(stored.Hash)) != 1 {
+			return ErrUnauthorized
+		}
+		role = stored.Role
+		return nil
+	})
+	return role, err
+}
+
+// Method for check requests need API key: they do if service requires it,
+// has admin key or any key was ever issued (revoked keys count too), so
+// issued keys can't be bypassed by leaving them out of request.
+func (service *Service) APIKeyRequired() (bool, error) {
+	if service.RequireAPIKey || service.AdminKey != "" {
+		return true, nil
+	}
+
+	var exists bool
+	err := service.store.Transactional(func(tx StoreTx) error {
+		var err error
+		exists, err = tx.HasAPIKeys()
+		return err
+	})
+	if err != nil {
+		service.Logger.Error("database error", "error", err)
+		return true, err
+	}
+	return exists, nil
+}
+
+// API key from X-API-Key or Authorization: Bearer header
+func requestAPIKey(c *routing.Context) string {
+	if key := c.Request.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	auth := c.Request.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// Middleware for check API key of request has role (admin has every role).
+// Does nothing while API keys are not required, see APIKeyRequired.
+func authHandler(service Service, role string) routing.Handler {
+	return func(c *routing.Context) error {
+		service := requestService(c, service)
+		required, err := service.APIKeyRequired()
+		if err != nil {
+			return err
+		}
+		if !required {
+			return nil
+		}
+
+		key := requestAPIKey(c)
+		if key == "" {
+			return ErrUnauthorized
+		}
+
+		keyRole, err := service.Authenticate(key)
+		if err != nil {
+			return err
+		}
+		if keyRole != role && keyRole != RoleAdmin {
+			return ErrForbidden
+		}
+		return nil
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeys(t *testing.T) {
+
+	server := httptest.NewServer(newRouter(Service{store: NewMemoryStore(), RequireAPIKey: true, AdminKey: "secret"}))
+	defer server.Close()
+
+	request := func(method string, path string, key string, body string, status int, data interface{}) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		assert.Equal(t, status, res.StatusCode, method+" "+path)
+		if data != nil {
+			json.NewDecoder(res.Body).Decode(data)
+		}
+	}
+
+	// Key is required, OpenAPI document is public
+	var apiError APIError
+	request("GET", "/balance?playerId=P1", "", ``, 401, &apiError)
+	assert.Equal(t, "unauthorized", apiError.Code)
+	request("GET", "/balance?playerId=P1", "wrong.key", ``, 401, nil)
+	request("GET", "/openapi.json", "", ``, 200, nil)
+
+	// Admin key from service config
+	request("GET", "/fund?playerId=P1&points=100", "secret", ``, 200, nil)
+
+	var client IssuedAPIKey
+	request("POST", "/v2/apikeys", "secret", `{"role": "client", "name": "web"}`, 201, &client)
+	assert.Equal(t, RoleClient, client.Role)
+	assert.Equal(t, "web", client.Name)
+	assert.True(t, strings.HasPrefix(client.Key, client.ID+"."))
+
+	request("POST", "/v2/apikeys", "secret", `{"role": "root"}`, 400, nil)
+
+	// Client can read balances and take points, but not fund or reset
+	var player Players
+	request("GET", "/balance?playerId=P1", client.Key, ``, 200, &player)
+	assert.Equal(t, int64(100), player.Balance)
+	request("POST", "/v2/players/P1/take", client.Key, `{"points": 10}`, 200, nil)
+	request("GET", "/fund?playerId=P1&points=100", client.Key, ``, 403, &apiError)
+	assert.Equal(t, "forbidden", apiError.Code)
+	request("POST", "/v2/reset", client.Key, ``, 403, nil)
+	request("GET", "/v2/apikeys", client.Key, ``, 403, nil)
+
+	// Bearer token is accepted too
+	req, _ := http.NewRequest("GET", server.URL+"/v2/players/P1", nil)
+	req.Header.Set("Authorization", "Bearer "+client.Key)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+
+	// Listed keys don't have secrets
+	var keys []map[string]interface{}
+	request("GET", "/v2/apikeys", "secret", ``, 200, &keys)
+	if assert.Len(t, keys, 1) {
+		assert.Equal(t, client.ID, keys[0]["id"])
+		assert.NotContains(t, keys[0], "key")
+		assert.NotContains(t, keys[0], "hash")
+	}
+
+	// Revoked key is rejected, revoking again is fine
+	request("DELETE", "/v2/apikeys/"+client.ID, "secret", ``, 204, nil)
+	request("DELETE", "/v2/apikeys/"+client.ID, "secret", ``, 204, nil)
+	request("DELETE", "/v2/apikeys/unknown", "secret", ``, 404, nil)
+	request("GET", "/balance?playerId=P1", client.Key, ``, 401, nil)
+
+	// Issued admin key can manage keys, reset keeps keys
+	var admin IssuedAPIKey
+	request("POST", "/v2/apikeys", "secret", `{"role": "admin"}`, 201, &admin)
+	request("POST", "/v2/reset", admin.Key, ``, 200, nil)
+	request("GET", "/v2/apikeys", admin.Key, ``, 200, nil)
+}
+
+func TestAPIKeysNotRequired(t *testing.T) {
+
+	server := httptest.NewServer(newRouter(Service{store: NewMemoryStore()}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/fund?playerId=P1&points=100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+
+	// The first issued key turns API keys on, revoked keys keep them on
+	var issued IssuedAPIKey
+	res, err = http.Post(server.URL+"/v2/apikeys", "application/json", strings.NewReader(`{"role": "admin"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(res.Body).Decode(&issued)
+	res.Body.Close()
+	assert.Equal(t, 201, res.StatusCode)
+
+	for _, revoke := range []bool{false, true} {
+		if revoke {
+			req, _ := http.NewRequest("DELETE", server.URL+"/v2/apikeys/"+issued.ID, nil)
+			req.Header.Set("X-API-Key", issued.Key)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			assert.Equal(t, 204, res.StatusCode, "Key is revoked")
+		}
+
+		res, err := http.Get(server.URL + "/fund?playerId=P1&points=100")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, 401, res.StatusCode, "Request without key")
+	}
+}
+
+func TestAPIKeyRequiredByStore(t *testing.T) {
+
+	store, closeStore := newTestStore(t)
+	defer closeStore()
+	service := Service{store: store}
+
+	required, err := service.APIKeyRequired()
+	assert.NoError(t, err)
+	assert.False(t, required, "API keys aren't required without keys")
+
+	// Issued and then revoked key keeps them required
+	issued, err := service.IssueAPIKey(RoleClient, "web")
+	assert.NoError(t, err)
+	assert.NoError(t, service.RevokeAPIKey(issued.ID))
+
+	required, err = service.APIKeyRequired()
+	assert.NoError(t, err)
+	assert.True(t, required, "API keys are required after key is issued")
+}
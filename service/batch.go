@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Batch execution modes
+const (
+	// All operations are applied or none of them
+	BatchAtomic = "atomic"
+
+	// Every operation is applied on its own, failed operations are skipped
+	BatchBestEffort = "bestEffort"
+)
+
+// Batch operations
+const (
+	BatchFund = "fund"
+	BatchTake = "take"
+)
+
+// Max number of operations in one batch
+const maxBatchOperations = 10000
+
+// Structure for one fund or take operation of batch
+type BatchOperation struct {
+	Op       string `json:"op"`
+	PlayerID string `json:"playerId"`
+	Points   int64  `json:"points"`
+}
+
+// Structure for result of batch operation, Error is set if it's not applied
+type BatchResult struct {
+	BatchOperation
+	Applied bool      `json:"applied"`
+	Error   *APIError `json:"error,omitempty"`
+}
+
+// Check operation before it's applied
+func validBatchOperation(op BatchOperation) error {
+	if op.Op != BatchFund && op.Op != BatchTake {
+		return ErrInvalidBatchOperation
+	}
+	if op.PlayerID == "" || op.Points <= 0 {
+		return ErrInvalidBatchOperation
+	}
+	return nil
+}
+
+// Method for apply fund and take operations in atomic or best-effort mode.
+// Domain errors are reported per operation. Other errors fail atomic batch,
+// in best-effort mode they are reported per operation as internal_error,
+// because operations before them are already applied.
+func (service *Service) Batch(mode string, operations []BatchOperation) ([]BatchResult, error) {
+	results := make([]BatchResult, len(operations))
+	for i, op := range operations {
+		results[i].BatchOperation = op
+	}
+
+	switch mode {
+	case BatchBestEffort:
+		for i, op := range operations {
+			err := validBatchOperation(op)
+			if err == nil && op.Op == BatchFund {
+				err = service.Fund(op.PlayerID, op.Points)
+			}
+			if err == nil && op.Op == BatchTake {
+				err = service.Take(op.PlayerID, op.Points)
+			}
+			if err := results[i].set(err); err != nil {
+				results[i].Error = &APIError{http.StatusInternalServerError, "internal_error", "internal error"}
+			}
+		}
+
+	case BatchAtomic:
+		failed := -1
+		err := service.store.Transactional(func(tx StoreTx) error {
+			for i, op := range operations {
+				err := validBatchOperation(op)
+				if err == nil && op.Op == BatchFund {
+					err = service.fundPlayer(tx, op.PlayerID, op.Points)
+				}
+				if err == nil && op.Op == BatchTake {
+					err = takePlayer(tx, op.PlayerID, op.Points)
+				}
+				if err != nil {
+					failed = i
+					return err
+				}
+			}
+			return nil
+		})
+
+		if err != nil && failed < 0 {
+			service.Logger.Error("database error", "error", err, "mode", mode)
+			return nil, err
+		}
+
+		// Failed operation gets its error, the rest are rolled back with it
+		for i := range results {
+			switch {
+			case failed < 0:
+				results[i].set(nil)
+				service.Metrics.operation(results[i].Op, results[i].Points)
+			case i == failed:
+				if err := results[i].set(err); err != nil {
+					service.Logger.Error("database error", "error", err, "mode", mode, "op", results[i].Op, "playerId", results[i].PlayerID)
+					return nil, err
+				}
+			default:
+				results[i].set(ErrBatchAborted)
+			}
+		}
+
+	default:
+		return nil, ErrInvalidBatchMode
+	}
+
+	return results, nil
+}
+
+// Set result of operation, errors other than domain errors are returned back
+func (result *BatchResult) set(err error) error {
+	if err == nil {
+		result.Applied = true
+		return nil
+	}
+
+	e, ok := err.(*Error)
+	if !ok {
+		return err
+	}
+	result.Error = &APIError{errorStatus(e), e.Code, e.Message}
+	return nil
+}
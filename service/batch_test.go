@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+
+	store := NewMemoryStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+	service := Service{store: store}
+
+	type response struct {
+		Applied int           `json:"applied"`
+		Failed  int           `json:"failed"`
+		Results []BatchResult `json:"results"`
+	}
+
+	batch := func(body string) (int, response) {
+		var r response
+		res, err := http.Post(server.URL+"/v2/batch", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&r)
+		return res.StatusCode, r
+	}
+
+	balance := func(id string) int64 {
+		player, _ := service.PlayerBalance(id)
+		return player.Balance
+	}
+
+	operations := `[
+		{"op": "fund", "playerId": "P1", "points": 100},
+		{"op": "fund", "playerId": "P2", "points": 100},
+		{"op": "take", "playerId": "P1", "points": 300}
+	]`
+
+	// Atomic batch fails as a whole
+	status, r := batch(`{"operations": ` + operations + `}`)
+	assert.Equal(t, 200, status, "Atomic batch")
+	assert.Equal(t, 0, r.Applied)
+	assert.Equal(t, 3, r.Failed)
+	if assert.Len(t, r.Results, 3) {
+		assert.Equal(t, "batch_aborted", r.Results[0].Error.Code)
+		assert.Equal(t, "insufficient_funds", r.Results[2].Error.Code)
+	}
+	assert.Equal(t, int64(0), balance("P1"), "Fund of P1 is rolled back")
+
+	// Best-effort batch skips failed operation
+	status, r = batch(`{"mode": "bestEffort", "operations": ` + operations + `}`)
+	assert.Equal(t, 200, status, "Best-effort batch")
+	assert.Equal(t, 2, r.Applied)
+	if assert.Len(t, r.Results, 3) {
+		assert.True(t, r.Results[0].Applied)
+		assert.Nil(t, r.Results[0].Error)
+		assert.Equal(t, "insufficient_funds", r.Results[2].Error.Code)
+	}
+	assert.Equal(t, int64(100), balance("P1"))
+	assert.Equal(t, int64(100), balance("P2"))
+
+	// Atomic batch applies everything
+	status, r = batch(`{"mode": "atomic", "operations": [
+		{"op": "take", "playerId": "P1", "points": 100},
+		{"op": "fund", "playerId": "P3", "points": 50}
+	]}`)
+	assert.Equal(t, 200, status, "Atomic batch")
+	assert.Equal(t, 2, r.Applied)
+	assert.Equal(t, int64(0), balance("P1"))
+	assert.Equal(t, int64(50), balance("P3"))
+
+	status, _ = batch(`{"mode": "parallel", "operations": ` + operations + `}`)
+	assert.Equal(t, 400, status, "Unknown mode")
+	status, _ = batch(`{"operations": []}`)
+	assert.Equal(t, 400, status, "Empty batch")
+}
+
+// Memory store which fails to fund one player like broken database
+type failingFundStore struct {
+	*MemoryStore
+	player string
+}
+
+func (store *failingFundStore) Transactional(fn func(tx StoreTx) error) error {
+	return store.MemoryStore.Transactional(func(tx StoreTx) error {
+		return fn(failingFundTx{tx, store.player})
+	})
+}
+
+type failingFundTx struct {
+	StoreTx
+	player string
+}
+
+func (tx failingFundTx) FundPlayer(id string, points int64) error {
+	if id == tx.player {
+		return errors.New("connection reset")
+	}
+	return tx.StoreTx.FundPlayer(id, points)
+}
+
+func TestBatchBestEffortDatabaseError(t *testing.T) {
+
+	store := &failingFundStore{NewMemoryStore(), "P2"}
+	server := httptest.NewServer(newRouter(Service{store: store}))
+	defer server.Close()
+	service := Service{store: store}
+
+	type response struct {
+		Applied int           `json:"applied"`
+		Failed  int           `json:"failed"`
+		Results []BatchResult `json:"results"`
+	}
+
+	batch := func() (int, response) {
+		var r response
+		body := `{"mode": "bestEffort", "operations": [
+			{"op": "fund", "playerId": "P1", "points": 100},
+			{"op": "fund", "playerId": "P2", "points": 100},
+			{"op": "fund", "playerId": "P3", "points": 100}
+		]}`
+		req, err := http.NewRequest("POST", server.URL+"/v2/batch", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "batch-1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&r)
+		return res.StatusCode, r
+	}
+
+	balance := func(id string) int64 {
+		player, _ := service.PlayerBalance(id)
+		return player.Balance
+	}
+
+	// Failed operation is reported, the rest are applied
+	status, r := batch()
+	assert.Equal(t, 200, status, "Best-effort batch")
+	assert.Equal(t, 2, r.Applied)
+	assert.Equal(t, 1, r.Failed)
+	if assert.Len(t, r.Results, 3) {
+		assert.True(t, r.Results[0].Applied)
+		assert.False(t, r.Results[1].Applied)
+		assert.Equal(t, "internal_error", r.Results[1].Error.Code)
+		assert.True(t, r.Results[2].Applied)
+	}
+
+	// Retry gets the same response and applies nothing again
+	status, replay := batch()
+	assert.Equal(t, 200, status, "Replayed batch")
+	assert.Equal(t, r, replay)
+	assert.Equal(t, int64(100), balance("P1"))
+	assert.Equal(t, int64(0), balance("P2"))
+	assert.Equal(t, int64(100), balance("P3"))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestCancelTournament(t *testing.T) {
+
+	store := NewMemoryStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, p := range []string{"P1", "P2", "P3", "P4"} {
+		get(fmt.Sprintf("/fund?playerId=%s&points=1000", p))
+	}
+
+	// House covers 1 point of deposit split between three participants
+	get("/announceTournament?tournamentId=1&deposit=1000&splitPolicy=house")
+	get("/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3")
+	get("/joinTournament?tournamentId=1&playerId=P4")
+
+	// Cancel twice, the second cancel doesn't refund again
+	for i := 0; i < 2; i++ {
+		res := get("/cancelTournament?tournamentId=1")
+		assert.Equal(t, 200, res.StatusCode, "Tournament is cancelled")
+	}
+
+	for _, p := range []string{"P1", "P2", "P3", "P4"} {
+		var player Players
+		res := get("/balance?playerId=" + p)
+		json.NewDecoder(res.Body).Decode(&player)
+		res.Body.Close()
+		assert.Equal(t, int64(1000), player.Balance, "Balance for ", p)
+	}
+
+	// Tournament and house accounts are empty again
+	store.Transactional(func(tx StoreTx) error {
+		balances, err := tx.LedgerBalances()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), balances[tournamentAccount("1")], "Tournament balance")
+		assert.Equal(t, int64(0), balances[houseAccount], "House balance")
+		return nil
+	})
+
+	res := get("/joinTournament?tournamentId=1&playerId=P1")
+	assert.Equal(t, 400, res.StatusCode, "Cancelled tournament can't be joined")
+
+	get("/announceTournament?tournamentId=2&deposit=1000")
+	res = get("/cancelTournament?tournamentId=2")
+	assert.Equal(t, 200, res.StatusCode, "Tournament without games is cancelled")
+}
+
+func TestCancelTournamentConcurrently(t *testing.T) {
+
+	store, closeStore := newTestStore(t)
+	defer closeStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+
+	get := func(path string) int {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	for _, p := range []string{"P1", "P2"} {
+		get(fmt.Sprintf("/fund?playerId=%s&points=1000", p))
+	}
+	get("/announceTournament?tournamentId=1&deposit=1000")
+	get("/joinTournament?tournamentId=1&playerId=P1&backerId=P2")
+
+	// Concurrent cancels refund once
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, 200, get("/cancelTournament?tournamentId=1"), "Tournament is cancelled")
+		}()
+	}
+	wg.Wait()
+
+	for _, p := range []string{"P1", "P2"} {
+		var player Players
+		res, err := http.Get(server.URL + "/balance?playerId=" + p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		json.NewDecoder(res.Body).Decode(&player)
+		res.Body.Close()
+		assert.Equal(t, int64(1000), player.Balance, "Balance for ", p)
+	}
+}
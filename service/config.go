@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Structure for service configuration. Settings are loaded from defaults,
+// then JSON config file, then environment variables, then command line flags,
+// every next source overrides the previous one.
+type Config struct {
+	Server   ServerConfig   `json:"server"`
+	Database DatabaseConfig `json:"database"`
+	Log      LogConfig      `json:"log"`
+	Service  ServiceConfig  `json:"service"`
+	Features FeaturesConfig `json:"features"`
+}
+
+// HTTP server settings
+type ServerConfig struct {
+	Addr           string   `json:"addr"`
+	ReadTimeout    Duration `json:"readTimeout"`
+	WriteTimeout   Duration `json:"writeTimeout"`
+	MaxHeaderBytes int      `json:"maxHeaderBytes"`
+
+	// Time requests are refused before shutdown, so load balancers
+	// notice server isn't ready
+	ShutdownDelay Duration `json:"shutdownDelay"`
+
+	// Time for in-flight requests to finish on shutdown
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+}
+
+// Storage settings, storage is "postgres" or "memory"
+type DatabaseConfig struct {
+	Storage         string   `json:"storage"`
+	DSN             string   `json:"dsn"`
+	MaxOpenConns    int      `json:"maxOpenConns"`
+	MaxIdleConns    int      `json:"maxIdleConns"`
+	ConnMaxLifetime Duration `json:"connMaxLifetime"`
+	AutoMigrate     bool     `json:"autoMigrate"`
+}
+
+// Logging settings, level is debug, info, warn or error
+type LogConfig struct {
+	Level string `json:"level"`
+}
+
+// Business settings of Social Tournament service
+type ServiceConfig struct {
+	Mode               string `json:"mode"`
+	DefaultSplitPolicy string `json:"defaultSplitPolicy"`
+	AdminAPIKey        string `json:"adminApiKey"`
+	ConfirmToken       string `json:"confirmToken"`
+}
+
+// Feature toggles
+type FeaturesConfig struct {
+	ImplicitPlayers bool `json:"implicitPlayers"`
+}
+
+// Storages
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// Duration which is "100s" or "1m30s" in config file
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be string like \"100s\"")
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+// Idle connections kept in pool, the same as database/sql keeps by default
+const defaultMaxIdleConns = 2
+
+// Configuration used without config file, environment and flags
+func defaultConfig() Config {
+	return Config{
+		Server: ServerConfig{
+			Addr:            ":8080",
+			ReadTimeout:     Duration{100 * time.Second},
+			WriteTimeout:    Duration{100 * time.Second},
+			MaxHeaderBytes:  1 << 20,
+			ShutdownDelay:   Duration{5 * time.Second},
+			ShutdownTimeout: Duration{30 * time.Second},
+		},
+		Database: DatabaseConfig{
+			Storage:      StoragePostgres,
+			MaxIdleConns: defaultMaxIdleConns,
+			AutoMigrate:  true,
+		},
+		Log: LogConfig{Level: LogInfo},
+		Service: ServiceConfig{
+			Mode:               ModeDev,
+			DefaultSplitPolicy: defaultSplitPolicy,
+		},
+		Features: FeaturesConfig{ImplicitPlayers: true},
+	}
+}
+
+// Setting which can be set by environment variable and command line flag
+type configOption struct {
+	env   string
+	flag  string
+	usage string
+	set   func(config *Config, value string) error
+}
+
+var configOptions = []configOption{
+	{"LISTEN_ADDR", "addr", "listen address", func(c *Config, v string) error {
+		c.Server.Addr = v
+		return nil
+	}},
+	{"READ_TIMEOUT", "read-timeout", "HTTP read timeout", func(c *Config, v string) error {
+		return setDuration(&c.Server.ReadTimeout, v)
+	}},
+	{"WRITE_TIMEOUT", "write-timeout", "HTTP write timeout", func(c *Config, v string) error {
+		return setDuration(&c.Server.WriteTimeout, v)
+	}},
+	{"MAX_HEADER_BYTES", "max-header-bytes", "max size of request headers", func(c *Config, v string) error {
+		return setInt(&c.Server.MaxHeaderBytes, v)
+	}},
+	{"SHUTDOWN_DELAY", "shutdown-delay", "time requests are refused before shutdown", func(c *Config, v string) error {
+		return setDuration(&c.Server.ShutdownDelay, v)
+	}},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "time for in-flight requests to finish on shutdown", func(c *Config, v string) error {
+		return setDuration(&c.Server.ShutdownTimeout, v)
+	}},
+	{"STORAGE", "storage", "storage, postgres or memory", func(c *Config, v string) error {
+		c.Database.Storage = v
+		return nil
+	}},
+	{"SQL_DB", "dsn", "PostgreSQL connection string", func(c *Config, v string) error {
+		c.Database.DSN = v
+		return nil
+	}},
+	{"DB_MAX_OPEN_CONNS", "db-max-open-conns", "max open database connections, 0 is unlimited", func(c *Config, v string) error {
+		return setInt(&c.Database.MaxOpenConns, v)
+	}},
+	{"DB_MAX_IDLE_CONNS", "db-max-idle-conns", "max idle database connections, 0 keeps none", func(c *Config, v string) error {
+		return setInt(&c.Database.MaxIdleConns, v)
+	}},
+	{"DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "max lifetime of database connection, 0 is unlimited", func(c *Config, v string) error {
+		return setDuration(&c.Database.ConnMaxLifetime, v)
+	}},
+	{"AUTO_MIGRATE", "auto-migrate", "apply pending migrations at startup", func(c *Config, v string) error {
+		return setBool(&c.Database.AutoMigrate, v)
+	}},
+	{"LOG_LEVEL", "log-level", "log level, debug, info, warn or error", func(c *Config, v string) error {
+		c.Log.Level = v
+		return nil
+	}},
+	{"MODE", "mode", "mode, dev, test or prod", func(c *Config, v string) error {
+		c.Service.Mode = v
+		return nil
+	}},
+	{"DEFAULT_SPLIT_POLICY", "default-split-policy", "split policy of tournaments announced without policy", func(c *Config, v string) error {
+		c.Service.DefaultSplitPolicy = v
+		return nil
+	}},
+	{"ADMIN_API_KEY", "", "", func(c *Config, v string) error {
+		c.Service.AdminAPIKey = v
+		return nil
+	}},
+	{"CONFIRM_TOKEN", "", "", func(c *Config, v string) error {
+		c.Service.ConfirmToken = v
+		return nil
+	}},
+	{"IMPLICIT_PLAYERS", "implicit-players", "create players on first fund", func(c *Config, v string) error {
+		return setBool(&c.Features.ImplicitPlayers, v)
+	}},
+}
+
+func setDuration(d *Duration, value string) error {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("must be duration like 100s")
+	}
+	d.Duration = duration
+	return nil
+}
+
+func setInt(n *int, value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("must be integer")
+	}
+	*n = i
+	return nil
+}
+
+func setBool(b *bool, value string) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("must be true or false")
+	}
+	*b = v
+	return nil
+}
+
+// Error with every problem found in configuration
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Load configuration from file, environment and command line args.
+// Config file is set by -config flag or CONFIG_FILE environment variable.
+// Args left after flags are returned, e.g. migrate command.
+func loadConfig(args []string, getenv func(string) string) (Config, []string, error) {
+	config := defaultConfig()
+	var problems []string
+
+	// Flags are parsed first to find config file, applied last
+	flags := flag.NewFlagSet("stservice", flag.ContinueOnError)
+	file := flags.String("config", getenv("CONFIG_FILE"), "JSON config file")
+	values := map[string]*string{}
+	for _, option := range configOptions {
+		if option.flag != "" {
+			values[option.flag] = flags.String(option.flag, "", option.usage)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return config, nil, err
+	}
+
+	if *file != "" {
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			problems = append(problems, "config file: "+err.Error())
+		} else if err := json.Unmarshal(data, &config); err != nil {
+			problems = append(problems, "config file "+*file+": "+err.Error())
+		}
+	}
+
+	for _, option := range configOptions {
+		if value := getenv(option.env); value != "" {
+			if err := option.set(&config, value); err != nil {
+				problems = append(problems, option.env+": "+err.Error())
+			}
+		}
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		for _, option := range configOptions {
+			if option.flag == f.Name {
+				if err := option.set(&config, *values[f.Name]); err != nil {
+					problems = append(problems, "-"+f.Name+": "+err.Error())
+				}
+			}
+		}
+	})
+
+	problems = append(problems, config.problems()...)
+	if len(problems) > 0 {
+		return config, flags.Args(), &ConfigError{problems}
+	}
+	return config, flags.Args(), nil
+}
+
+// Validate configuration, every problem is returned
+func (config Config) problems() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if config.Server.Addr == "" {
+		add("server.addr is required")
+	}
+	if config.Server.ReadTimeout.Duration <= 0 {
+		add("server.readTimeout must be positive")
+	}
+	if config.Server.WriteTimeout.Duration <= 0 {
+		add("server.writeTimeout must be positive")
+	}
+	if config.Server.MaxHeaderBytes <= 0 {
+		add("server.maxHeaderBytes must be positive")
+	}
+	if config.Server.ShutdownDelay.Duration < 0 {
+		add("server.shutdownDelay can't be negative")
+	}
+	if config.Server.ShutdownTimeout.Duration <= 0 {
+		add("server.shutdownTimeout must be positive")
+	}
+
+	switch config.Database.Storage {
+	case StorageMemory:
+	case StoragePostgres:
+		if config.Database.DSN == "" {
+			add("database.dsn (SQL_DB) is required for postgres storage")
+		}
+	default:
+		add("database.storage must be %s or %s, got %q", StoragePostgres, StorageMemory, config.Database.Storage)
+	}
+	if config.Database.MaxOpenConns < 0 {
+		add("database.maxOpenConns can't be negative")
+	}
+	if config.Database.MaxIdleConns < 0 {
+		add("database.maxIdleConns can't be negative")
+	}
+	if config.Database.MaxOpenConns > 0 && config.Database.MaxIdleConns > config.Database.MaxOpenConns {
+		add("database.maxIdleConns can't be greater than database.maxOpenConns")
+	}
+	if config.Database.ConnMaxLifetime.Duration < 0 {
+		add("database.connMaxLifetime can't be negative")
+	}
+
+	if _, ok := logLevels[config.Log.Level]; !ok {
+		add("log.level must be debug, info, warn or error, got %q", config.Log.Level)
+	}
+
+	if _, err := parseMode(config.Service.Mode); err != nil {
+		add("service.mode: %v", err)
+	}
+	if config.Service.Mode == ModeProd && config.Service.AdminAPIKey == "" {
+		add("service.adminApiKey (ADMIN_API_KEY) is required in prod mode")
+	}
+	if !validSplitPolicy(config.Service.DefaultSplitPolicy) {
+		add("service.defaultSplitPolicy must be %s, %s or %s, got %q",
+			SplitPlayer, SplitRoundRobin, SplitHouse, config.Service.DefaultSplitPolicy)
+	}
+
+	return problems
+}
+
+// Load configuration of process, exit with all problems if it's invalid
+func mustLoadConfig() (Config, []string) {
+	config, args, err := loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	return config, args
+}
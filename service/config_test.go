@@ -0,0 +1,144 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestConfigSources(t *testing.T) {
+	file, err := ioutil.TempFile("", "stservice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{
+		"server": {"addr": ":9000", "readTimeout": "5s"},
+		"database": {"dsn": "dbname=file", "maxOpenConns": 20, "maxIdleConns": 5},
+		"log": {"level": "warn"},
+		"features": {"implicitPlayers": false}
+	}`)
+	file.Close()
+
+	env := map[string]string{
+		"CONFIG_FILE":          file.Name(),
+		"SQL_DB":               "dbname=env",
+		"DEFAULT_SPLIT_POLICY": SplitHouse,
+		"MODE":                 ModeTest,
+		"ADMIN_API_KEY":        "secret",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	// File overrides defaults, environment overrides file, flags override environment
+	config, args, err := loadConfig([]string{"-addr", ":9001", "-mode", "prod", "migrate", "up"}, getenv)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"migrate", "up"}, args)
+
+	assert.Equal(t, ":9001", config.Server.Addr)
+	assert.Equal(t, 5*time.Second, config.Server.ReadTimeout.Duration)
+	assert.Equal(t, 100*time.Second, config.Server.WriteTimeout.Duration)
+	assert.Equal(t, 1<<20, config.Server.MaxHeaderBytes)
+	assert.Equal(t, StoragePostgres, config.Database.Storage)
+	assert.Equal(t, "dbname=env", config.Database.DSN)
+	assert.Equal(t, 20, config.Database.MaxOpenConns)
+	assert.Equal(t, 5, config.Database.MaxIdleConns)
+	assert.True(t, config.Database.AutoMigrate)
+	assert.Equal(t, LogWarn, config.Log.Level)
+	assert.Equal(t, ModeProd, config.Service.Mode)
+	assert.Equal(t, "secret", config.Service.AdminAPIKey)
+	assert.Equal(t, SplitHouse, config.Service.DefaultSplitPolicy)
+	assert.False(t, config.Features.ImplicitPlayers)
+}
+
+func TestConfigProblems(t *testing.T) {
+	env := map[string]string{
+		"READ_TIMEOUT":      "soon",
+		"DB_MAX_OPEN_CONNS": "2",
+		"DB_MAX_IDLE_CONNS": "4",
+		"IMPLICIT_PLAYERS":  "maybe",
+		"LOG_LEVEL":         "trace",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	// Every problem is reported at once
+	_, _, err := loadConfig([]string{"-default-split-policy", "random", "-mode", "staging"}, getenv)
+	if assert.IsType(t, &ConfigError{}, err) {
+		assert.Equal(t, []string{
+			"READ_TIMEOUT: must be duration like 100s",
+			"IMPLICIT_PLAYERS: must be true or false",
+			"database.dsn (SQL_DB) is required for postgres storage",
+			"database.maxIdleConns can't be greater than database.maxOpenConns",
+			`log.level must be debug, info, warn or error, got "trace"`,
+			`service.mode: unknown mode "staging", must be dev, test or prod`,
+			`service.defaultSplitPolicy must be player, roundRobin or house, got "random"`,
+		}, err.(*ConfigError).Problems)
+	}
+
+	// Missing config file is a problem too
+	env = map[string]string{"STORAGE": "memory", "CONFIG_FILE": "/nonexistent.json"}
+	_, _, err = loadConfig(nil, getenv)
+	if assert.IsType(t, &ConfigError{}, err) {
+		assert.Len(t, err.(*ConfigError).Problems, 1)
+	}
+
+	// Production needs admin API key
+	env = map[string]string{"STORAGE": "memory", "MODE": "prod"}
+	_, _, err = loadConfig(nil, getenv)
+	if assert.IsType(t, &ConfigError{}, err) {
+		assert.Equal(t, []string{
+			"service.adminApiKey (ADMIN_API_KEY) is required in prod mode",
+		}, err.(*ConfigError).Problems)
+	}
+
+	config, _, err := loadConfig(nil, func(name string) string {
+		return map[string]string{"STORAGE": "memory"}[name]
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, defaultSplitPolicy, config.Service.DefaultSplitPolicy)
+}
+
+func TestConfigConnectionPool(t *testing.T) {
+	env := map[string]string{"SQL_DB": "dbname=app"}
+	getenv := func(name string) string { return env[name] }
+
+	// Pool keeps idle connections unless it's configured otherwise
+	config, _, err := loadConfig(nil, getenv)
+	assert.NoError(t, err)
+	assert.Equal(t, defaultMaxIdleConns, config.Database.MaxIdleConns)
+	assert.True(t, config.Database.MaxIdleConns > 0, "Idle connections are kept")
+
+	env["DB_MAX_IDLE_CONNS"] = "0"
+	config, _, err = loadConfig(nil, getenv)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, config.Database.MaxIdleConns)
+}
+
+func TestConfigLogLevel(t *testing.T) {
+	env := map[string]string{"STORAGE": "memory", "LOG_LEVEL": "warn"}
+	getenv := func(name string) string { return env[name] }
+
+	// Level from environment or flag is the level of service logger
+	config, _, err := loadConfig(nil, getenv)
+	assert.NoError(t, err)
+	assert.Equal(t, LogWarn, config.Log.Level)
+
+	logger := NewLogger(&logBuffer{}, config.Log.Level)
+	assert.False(t, logger.Enabled(LogInfo), "Access log is off at warn level")
+	assert.True(t, logger.Enabled(LogWarn))
+
+	config, _, err = loadConfig([]string{"-log-level", "debug"}, getenv)
+	assert.NoError(t, err)
+	assert.Equal(t, LogDebug, config.Log.Level)
+	assert.True(t, NewLogger(&logBuffer{}, config.Log.Level).Enabled(LogDebug))
+}
+
+func TestDefaultSplitPolicyOfService(t *testing.T) {
+	service := Service{store: NewMemoryStore(), DefaultSplitPolicy: SplitRoundRobin}
+	assert.NoError(t, service.AnnounceTournament("1", 100, "", true))
+
+	details, err := service.TournamentDetails("1")
+	assert.NoError(t, err)
+	assert.Equal(t, SplitRoundRobin, details.SplitPolicy)
+}
@@ -2,9 +2,8 @@ package main
 
 import (
 	"github.com/go-ozzo/ozzo-routing"
-	"log"
-	"net/http"
 	"strconv"
+	"time"
 )
 
 // Announce tournament specifying the entry deposit Controller
@@ -12,41 +11,220 @@ func announceTournamentController(c *routing.Context, service Service) error {
 	// Get params
 	tournament := c.Query("tournamentId")
 	d := c.Query("deposit")
+	splitPolicy := c.Query("splitPolicy")
+	openRegistration := c.Query("openRegistration") != "false"
 
 	// Check input params
 	if tournament == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "tournamentId is requred")
+		return badRequest("tournamentId is requred")
 	}
 
 	if d == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "deposit is requred")
+		return badRequest("deposit is requred")
 	}
 
 	// deposit must be integer
 	deposit, err := strconv.ParseInt(d, 10, 64)
 	if err != nil {
-		log.Println("AnnounceTournament:", err)
-		return routing.NewHTTPError(http.StatusBadRequest, err.Error())
+		requestLogger(c).Debug("invalid deposit", "error", err)
+		return badRequest(err.Error())
 	}
 
 	// Run AnnounceTournament method of ST service
-	err = service.AnnounceTournament(tournament, deposit)
+	// Errors are converted to API errors by convertError
+	err = service.AnnounceTournament(tournament, deposit, splitPolicy, openRegistration)
 	if err != nil {
-		log.Println("AnnounceTournament:", err)
-		return routing.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
 
 	// If no errors response 200 with empty JSON Object
 	return c.Write(map[string]string{})
 }
 
+// Move tournament to new status Controller
+// (open or close registration, start or cancel tournament)
+func tournamentStatusController(c *routing.Context, service Service, status string) error {
+	// tournamentId is required
+	tournament := c.Query("tournamentId")
+	if tournament == "" {
+		return badRequest("tournamentId is requred")
+	}
+
+	// Run ChangeTournamentStatus method of ST service
+	err := service.ChangeTournamentStatus(tournament, status)
+	if err != nil {
+		return err
+	}
+
+	// If no errors response 200 with empty JSON Object
+	return c.Write(map[string]string{})
+}
+
+// Cancel Tournament Controller
+func cancelTournamentController(c *routing.Context, service Service) error {
+	// tournamentId is required
+	tournament := c.Query("tournamentId")
+	if tournament == "" {
+		return badRequest("tournamentId is requred")
+	}
+
+	// Run CancelTournament method of ST service
+	err := service.CancelTournament(tournament)
+	if err != nil {
+		return err
+	}
+
+	// If no errors response 200 with empty JSON Object
+	return c.Write(map[string]string{})
+}
+
+// Tournament details Controller
+func tournamentController(c *routing.Context, service Service) error {
+	// tournamentId is required
+	tournament := c.Query("tournamentId")
+	if tournament == "" {
+		return badRequest("tournamentId is requred")
+	}
+
+	// Run TournamentDetails method of ST service
+	details, err := service.TournamentDetails(tournament)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(details)
+}
+
+// List tournaments Controller, filters are optional:
+// status (repeated), minDeposit, maxDeposit, createdFrom, createdTo (RFC 3339),
+// playerId, cursor and limit
+func tournamentsController(c *routing.Context, service Service) error {
+	filter := TournamentFilter{
+		Statuses: c.Request.URL.Query()["status"],
+		PlayerID: c.Query("playerId"),
+	}
+
+	var err error
+	if filter.MinDeposit, err = optionalInt(c, "minDeposit"); err != nil {
+		return err
+	}
+	if filter.MaxDeposit, err = optionalInt(c, "maxDeposit"); err != nil {
+		return err
+	}
+	if filter.CreatedFrom, err = optionalTime(c, "createdFrom"); err != nil {
+		return err
+	}
+	if filter.CreatedTo, err = optionalTime(c, "createdTo"); err != nil {
+		return err
+	}
+
+	if c.Query("limit") != "" {
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit <= 0 || limit > maxTournamentsLimit {
+			return badRequest("limit must be from 1 to " + strconv.Itoa(maxTournamentsLimit))
+		}
+		filter.Limit = limit
+	}
+
+	if c.Query("cursor") != "" {
+		cursor, err := decodeTournamentCursor(c.Query("cursor"))
+		if err != nil {
+			return badRequest("invalid cursor")
+		}
+		filter.After = cursor
+	}
+
+	// Run ListTournaments method of ST service
+	page, err := service.ListTournaments(filter)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(page)
+}
+
+// Player history Controller, filters are optional:
+// type (repeated), createdFrom, createdTo (RFC 3339), cursor and limit
+func historyController(c *routing.Context, service Service) error {
+	// playerId is required
+	id := c.Query("playerId")
+	if id == "" {
+		return badRequest("playerId is requred")
+	}
+
+	return writePlayerHistory(c, service, id)
+}
+
+// Parse history filters from query and write page of player history
+func writePlayerHistory(c *routing.Context, service Service, id string) error {
+	filter := HistoryFilter{
+		PlayerID: id,
+		Kinds:    c.Request.URL.Query()["type"],
+	}
+
+	var err error
+	if filter.CreatedFrom, err = optionalTime(c, "createdFrom"); err != nil {
+		return err
+	}
+	if filter.CreatedTo, err = optionalTime(c, "createdTo"); err != nil {
+		return err
+	}
+
+	if c.Query("limit") != "" {
+		limit, err := strconv.Atoi(c.Query("limit"))
+		if err != nil || limit <= 0 || limit > maxHistoryLimit {
+			return badRequest("limit must be from 1 to " + strconv.Itoa(maxHistoryLimit))
+		}
+		filter.Limit = limit
+	}
+
+	if c.Query("cursor") != "" {
+		before, err := strconv.ParseInt(c.Query("cursor"), 10, 64)
+		if err != nil || before <= 0 {
+			return badRequest("invalid cursor")
+		}
+		filter.Before = before
+	}
+
+	// Run PlayerHistory method of ST service
+	page, err := service.PlayerHistory(filter)
+	if err != nil {
+		return err
+	}
+
+	return c.Write(page)
+}
+
+// Parse optional integer param, returns nil if param is not given
+func optionalInt(c *routing.Context, param string) (*int64, error) {
+	if c.Query(param) == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseInt(c.Query(param), 10, 64)
+	if err != nil {
+		return nil, badRequest(param + " must be integer")
+	}
+	return &value, nil
+}
+
+// Parse optional RFC 3339 time param, returns zero time if param is not given
+func optionalTime(c *routing.Context, param string) (time.Time, error) {
+	if c.Query(param) == "" {
+		return time.Time{}, nil
+	}
+	value, err := time.Parse(time.RFC3339, c.Query(param))
+	if err != nil {
+		return time.Time{}, badRequest(param + " must be RFC 3339 time")
+	}
+	return value, nil
+}
+
 // Reset DB to initial state Controller
 func resetDBController(c *routing.Context, service Service) error {
 	// Run ResetDB method of ST service
 	err := service.ResetDB()
 	if err != nil {
-		log.Println("ResetDB:", err)
-		return routing.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
 
 	// If no errors response 200 with empty JSON Object
@@ -58,20 +236,13 @@ func playerBalanceController(c *routing.Context, service Service) error {
 	// Get and check playerId (playerId is required)
 	id := c.Query("playerId")
 	if id == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "playerId is requred")
+		return badRequest("playerId is requred")
 	}
 
 	// Run PlayerBalance method of ST service
 	player, err := service.PlayerBalance(id)
 	if err != nil {
-		e := err.Error()
-		// If no player id db set status 400
-		if e == "sql: no rows in result set" {
-			return routing.NewHTTPError(http.StatusBadRequest, "playerId not found")
-		}
-		// For other errors set default status 500
-		log.Println("PlayerBalanceDB:", err)
-		return routing.NewHTTPError(http.StatusInternalServerError, e)
+		return err
 	}
 	// Send result of balance query
 	return c.Write(player)
@@ -86,32 +257,31 @@ func fundController(c *routing.Context, service Service) error {
 	// Check params
 	// playerId is required
 	if id == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "playerId is requred")
+		return badRequest("playerId is requred")
 	}
 
 	// Points is required
 	if p == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "points is requred")
+		return badRequest("points is requred")
 	}
 
 	// Points must be integer
 	points, err := strconv.ParseInt(p, 10, 64)
 
 	if err != nil {
-		log.Println("Fund:", err)
-		return routing.NewHTTPError(http.StatusBadRequest, err.Error())
+		requestLogger(c).Debug("invalid points", "error", err)
+		return badRequest(err.Error())
 	}
 
 	// Points must be greater than 0
 	if points <= 0 {
-		return routing.NewHTTPError(http.StatusBadRequest, "invalid points")
+		return badRequest("invalid points")
 	}
 
 	// Run Fund method of ST service
 	err = service.Fund(id, points)
 	if err != nil {
-		log.Println("Fund:", err)
-		return routing.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
 
 	// If no errors response 200 with empty JSON Object
@@ -126,37 +296,32 @@ func takeController(c *routing.Context, service Service) error {
 	// Check params
 	// playerId is required
 	if id == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "playerId is requred")
+		return badRequest("playerId is requred")
 	}
 
 	// Points is required
 	if p == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "points is requred")
+		return badRequest("points is requred")
 	}
 
 	// Points must be integer
 	points, err := strconv.ParseInt(p, 10, 64)
 
 	if err != nil {
-		log.Println("Fund:", err)
-		return routing.NewHTTPError(http.StatusBadRequest, err.Error())
+		requestLogger(c).Debug("invalid points", "error", err)
+		return badRequest(err.Error())
 	}
 
 	// Points must be greater than 0
 	if points <= 0 {
-		return routing.NewHTTPError(http.StatusBadRequest, "invalid points")
+		return badRequest("invalid points")
 	}
 
 	// Run Take method of ST service
-	rows, err := service.Take(id, points)
+	// Player must exist and have enough points
+	err = service.Take(id, points)
 	if err != nil {
-		log.Println("Take:", err)
-		return routing.NewHTTPError(http.StatusInternalServerError, err.Error())
-	}
-
-	// If no rows updated set status 400, (playerId doesn't exist in database)
-	if rows == 0 {
-		return routing.NewHTTPError(http.StatusBadRequest, "playerId not found")
+		return err
 	}
 
 	// If no errors response 200 with empty JSON Object
@@ -173,31 +338,81 @@ func joinTournamentController(c *routing.Context, service Service) error {
 	// Check params
 	// playerId is required
 	if playerId == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "playerId is requred")
+		return badRequest("playerId is requred")
 	}
 
 	// tournamentId is required
 	if tournamentId == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "tournamentId is requred")
+		return badRequest("tournamentId is requred")
+	}
+
+	// Optional stakes: playerStake and one stake per backerId (in the same order)
+	stakes, err := parseStakes(c.Query("playerStake"), c.Request.URL.Query()["stake"], len(backers))
+	if err != nil {
+		return err
 	}
 
 	// Run JoinTournament method of ST service
-	err := service.JoinTournament(tournamentId, playerId, backers)
+	// playerId, tournamentId and backers must exist in database
+	err = service.JoinTournament(tournamentId, playerId, backers, stakes)
 	if err != nil {
-		e := err.Error()
-		// If no rows updated set status 400, (playerId or tournamentId  doesn't exist in database)
-		if e == "not found" {
-			return routing.NewHTTPError(http.StatusBadRequest, e)
-		}
-		// Response for other errors (set status 500)
-		log.Println("joinTournamentController:", err)
-		return routing.NewHTTPError(http.StatusInternalServerError, e)
+		return err
 	}
 
 	// If no errors response 200 with empty JSON Object
 	return c.Write(map[string]string{})
 }
 
+// Leave tournament Controller, player and backers get their points back
+func leaveTournamentController(c *routing.Context, service Service) error {
+	// Get params from request
+	playerId := c.Query("playerId")
+	tournamentId := c.Query("tournamentId")
+
+	// Check params
+	// playerId is required
+	if playerId == "" {
+		return badRequest("playerId is requred")
+	}
+
+	// tournamentId is required
+	if tournamentId == "" {
+		return badRequest("tournamentId is requred")
+	}
+
+	// Run LeaveTournament method of ST service
+	err := service.LeaveTournament(tournamentId, playerId)
+	if err != nil {
+		return err
+	}
+
+	// If no errors response 200 with empty JSON Object
+	return c.Write(map[string]string{})
+}
+
+// Parse stakes of player and backers, returns nil if no stakes are given
+func parseStakes(playerStake string, backerStakes []string, backersLen int) ([]int64, error) {
+	if playerStake == "" && len(backerStakes) == 0 {
+		return nil, nil
+	}
+
+	if playerStake == "" || len(backerStakes) != backersLen {
+		return nil, badRequest("playerStake and stake for every backerId are requred")
+	}
+
+	stakes := make([]int64, 0, 1+backersLen)
+	for _, s := range append([]string{playerStake}, backerStakes...) {
+		// Stakes must be not negative integers
+		stake, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || stake < 0 {
+			return nil, badRequest("invalid stake")
+		}
+		stakes = append(stakes, stake)
+	}
+
+	return stakes, nil
+}
+
 // Structures for parse JSON from resultTournament request
 type Winner struct {
 	PlayerId string `json:"playerId"`
@@ -215,35 +430,51 @@ func resultTournamentController(c *routing.Context, service Service) error {
 
 	// Get JSON from POST request
 	if err := c.Read(&postData); err != nil {
-		log.Println("resultTournamentController:", err)
-		return routing.NewHTTPError(http.StatusBadRequest, "bad request")
+		requestLogger(c).Debug("invalid JSON body", "error", err)
+		return badRequest("bad request")
 	}
 
 	// Validate fields in JSON
 	// Winners are required
 	if len(postData.Winners) == 0 {
-		return routing.NewHTTPError(http.StatusBadRequest, "bad request, empty winners")
+		return badRequest("bad request, empty winners")
 	}
 
 	// tournamentId is required
 	tournamentId := postData.TournamentId
 	if tournamentId == "" {
-		return routing.NewHTTPError(http.StatusBadRequest, "bad request, empty tournamentId")
+		return badRequest("bad request, empty tournamentId")
 	}
 
 	// Run ResultTournament method of ST service
-	err := service.ResultTournament(tournamentId, postData.Winners)
+	// tournamentId, winners and their backers must exist in database
+	err := service.ResultLegacyTournament(tournamentId, postData.Winners)
 	if err != nil {
-		e := err.Error()
-		// If no rows updated set status 400, (playerId or tournamentId or any backerId doesn't exist in database)
-		if e == "not found" {
-			return routing.NewHTTPError(http.StatusBadRequest, e)
-		}
-		// Response for other errors (set status 500)
-		log.Println("resultTournamentController:", err)
-		return routing.NewHTTPError(http.StatusInternalServerError, e)
+		return err
 	}
 
 	// If no errors response 200 with empty JSON Object
 	return c.Write(map[string]string{})
 }
+
+// Reconcile players balances with ledger Controller
+func reconcileController(c *routing.Context, service Service) error {
+	// Run Reconcile method of ST service
+	players, err := service.Reconcile()
+	if err != nil {
+		return err
+	}
+
+	// Count players which balance doesn't match ledger
+	mismatches := 0
+	for _, player := range players {
+		if !player.Matched {
+			mismatches++
+		}
+	}
+
+	return c.Write(map[string]interface{}{
+		"players":    players,
+		"mismatches": mismatches,
+	})
+}
@@ -11,9 +11,9 @@ import (
 
 func TestFundEndpoint(t *testing.T) {
 
-        db := initDatabase()
-        defer db.Close()
-	server := httptest.NewServer(initRouter(db))
+	store, closeStore := newTestStore(t)
+	defer closeStore()
+	server := httptest.NewServer(initRouter(store))
 	defer server.Close()
 
 	url := fmt.Sprintf("%s/fund", server.URL)
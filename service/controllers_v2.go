@@ -0,0 +1,424 @@
+package main
+
+import (
+	"github.com/go-ozzo/ozzo-routing"
+	"net/http"
+	"strconv"
+)
+
+// Legacy routes change state with GET and take params from query.
+// They keep working, responses have Deprecation header pointing to /v2.
+func deprecated(c *routing.Context) error {
+	c.Response.Header().Set("Deprecation", "true")
+	c.Response.Header().Set("Link", `</v2>; rel="successor-version"`)
+	return nil
+}
+
+// Register v2 API: resource-oriented URLs, state changes with POST and DELETE,
+// request params in JSON body
+func initRouterV2(v2 *routing.RouteGroup, service Service, admin routing.Handler, client routing.Handler, confirm routing.Handler, idempotent routing.Handler) {
+	v2.Get(`/apikeys`, admin, func(c *routing.Context) error { return apiKeysV2Controller(c, requestService(c, service)) })
+	v2.Post(`/apikeys`, admin, func(c *routing.Context) error { return issueAPIKeyV2Controller(c, requestService(c, service)) })
+	v2.Delete(`/apikeys/<id>`, admin, confirm, func(c *routing.Context) error { return revokeAPIKeyV2Controller(c, requestService(c, service)) })
+	v2.Post(`/batch`, admin, idempotent, func(c *routing.Context) error { return batchV2Controller(c, requestService(c, service)) })
+	v2.Post(`/players`, admin, idempotent, func(c *routing.Context) error { return createPlayerV2Controller(c, requestService(c, service)) })
+	v2.Get(`/players/<id>`, client, func(c *routing.Context) error { return playerV2Controller(c, requestService(c, service)) })
+	v2.Post(`/players/<id>/close`, admin, confirm, idempotent, func(c *routing.Context) error {
+		return playerStatusV2Controller(c, requestService(c, service), PlayerClosed)
+	})
+	v2.Post(`/players/<id>/freeze`, admin, idempotent, func(c *routing.Context) error {
+		return playerStatusV2Controller(c, requestService(c, service), PlayerFrozen)
+	})
+	v2.Post(`/players/<id>/fund`, admin, idempotent, func(c *routing.Context) error { return fundV2Controller(c, requestService(c, service)) })
+	v2.Get(`/players/<id>/history`, client, func(c *routing.Context) error {
+		return writePlayerHistory(c, requestService(c, service), c.Param("id"))
+	})
+	v2.Post(`/players/<id>/take`, client, idempotent, func(c *routing.Context) error { return takeV2Controller(c, requestService(c, service)) })
+	v2.Post(`/players/<id>/unfreeze`, admin, idempotent, func(c *routing.Context) error {
+		return playerStatusV2Controller(c, requestService(c, service), PlayerActive)
+	})
+	v2.Get(`/reconcile`, admin, func(c *routing.Context) error { return reconcileController(c, requestService(c, service)) })
+	if service.ResetEnabled() {
+		v2.Post(`/reset`, admin, func(c *routing.Context) error { return resetDBController(c, requestService(c, service)) })
+	}
+	v2.Get(`/status`, func(c *routing.Context) error { return statusController(c, requestService(c, service)) })
+	v2.Get(`/tournaments`, client, func(c *routing.Context) error { return tournamentsController(c, requestService(c, service)) })
+	v2.Post(`/tournaments`, admin, idempotent, func(c *routing.Context) error { return announceTournamentV2Controller(c, requestService(c, service)) })
+	v2.Get(`/tournaments/<id>`, client, func(c *routing.Context) error { return tournamentV2Controller(c, requestService(c, service)) })
+	v2.Post(`/tournaments/<id>/cancel`, admin, confirm, idempotent, func(c *routing.Context) error { return cancelTournamentV2Controller(c, requestService(c, service)) })
+	v2.Post(`/tournaments/<id>/close`, admin, idempotent, func(c *routing.Context) error {
+		return tournamentStatusV2Controller(c, requestService(c, service), StatusRegistrationClosed)
+	})
+	v2.Post(`/tournaments/<id>/entries`, client, idempotent, func(c *routing.Context) error { return joinTournamentV2Controller(c, requestService(c, service)) })
+	v2.Delete(`/tournaments/<id>/entries/<playerId>`, client, idempotent, func(c *routing.Context) error {
+		return leaveTournamentV2Controller(c, requestService(c, service))
+	})
+	v2.Post(`/tournaments/<id>/open`, admin, idempotent, func(c *routing.Context) error {
+		return tournamentStatusV2Controller(c, requestService(c, service), StatusRegistrationOpen)
+	})
+	v2.Post(`/tournaments/<id>/results`, admin, idempotent, func(c *routing.Context) error { return resultTournamentV2Controller(c, requestService(c, service)) })
+	v2.Post(`/tournaments/<id>/start`, admin, idempotent, func(c *routing.Context) error {
+		return tournamentStatusV2Controller(c, requestService(c, service), StatusRunning)
+	})
+	v2.Post(`/transfers`, client, idempotent, func(c *routing.Context) error { return transferV2Controller(c, requestService(c, service)) })
+}
+
+// Structure for create player request body
+type PlayerRequest struct {
+	PlayerID string `json:"playerId"`
+}
+
+// Structure for fund and take request body
+type PointsRequest struct {
+	Points int64 `json:"points"`
+}
+
+// Structure for batch request body, mode is atomic by default
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// Structure for transfer request body
+type TransferRequest struct {
+	FromPlayerID string `json:"fromPlayerId"`
+	ToPlayerID   string `json:"toPlayerId"`
+	Points       int64  `json:"points"`
+}
+
+// Structure for transfer response, balances after transfer
+type TransferResponse struct {
+	From Players `json:"from"`
+	To   Players `json:"to"`
+}
+
+// Structure for announce tournament request body.
+// Registration is open unless openRegistration is false.
+type TournamentRequest struct {
+	TournamentID     string `json:"tournamentId"`
+	Deposit          *int64 `json:"deposit"`
+	SplitPolicy      string `json:"splitPolicy"`
+	OpenRegistration *bool  `json:"openRegistration"`
+}
+
+// Structure for join tournament request body.
+// Stakes are optional, if playerStake is set every backer needs stake.
+type EntryRequest struct {
+	PlayerID    string          `json:"playerId"`
+	PlayerStake *int64          `json:"playerStake"`
+	Backers     []BackerRequest `json:"backers"`
+}
+
+type BackerRequest struct {
+	PlayerID string `json:"playerId"`
+	Stake    *int64 `json:"stake"`
+}
+
+// Structure for issue API key request body
+type APIKeyRequest struct {
+	Role string `json:"role"`
+	Name string `json:"name"`
+}
+
+// Read JSON body of request
+func readBody(c *routing.Context, data interface{}) error {
+	if err := c.Read(data); err != nil {
+		requestLogger(c).Debug("invalid JSON body", "error", err)
+		return badRequest("invalid JSON body")
+	}
+	return nil
+}
+
+// Read points from body, points must be greater than 0
+func readPoints(c *routing.Context) (int64, error) {
+	var body PointsRequest
+	if err := readBody(c, &body); err != nil {
+		return 0, err
+	}
+	if body.Points <= 0 {
+		return 0, badRequest("invalid points")
+	}
+	return body.Points, nil
+}
+
+// Write current player balance
+func writePlayer(c *routing.Context, service Service, id string) error {
+	player, err := service.PlayerBalance(id)
+	if err != nil {
+		return err
+	}
+	return c.Write(player)
+}
+
+// Write current tournament details with given HTTP status
+func writeTournament(c *routing.Context, service Service, id string, status int) error {
+	details, err := service.TournamentDetails(id)
+	if err != nil {
+		return err
+	}
+	c.Response.WriteHeader(status)
+	return c.Write(details)
+}
+
+// Player balance Controller
+func playerV2Controller(c *routing.Context, service Service) error {
+	return writePlayer(c, service, c.Param("id"))
+}
+
+// Create player Controller, responds 201 with new player
+func createPlayerV2Controller(c *routing.Context, service Service) error {
+	var body PlayerRequest
+	if err := readBody(c, &body); err != nil {
+		return err
+	}
+
+	if body.PlayerID == "" {
+		return badRequest("playerId is requred")
+	}
+
+	player, err := service.CreatePlayer(body.PlayerID)
+	if err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusCreated)
+	return c.Write(player)
+}
+
+// Change player status Controller, responds with player
+func playerStatusV2Controller(c *routing.Context, service Service, status string) error {
+	player, err := service.ChangePlayerStatus(c.Param("id"), status)
+	if err != nil {
+		return err
+	}
+	return c.Write(player)
+}
+
+// Fund player Controller, responds with new balance
+func fundV2Controller(c *routing.Context, service Service) error {
+	points, err := readPoints(c)
+	if err != nil {
+		return err
+	}
+
+	if err := service.Fund(c.Param("id"), points); err != nil {
+		return err
+	}
+	return writePlayer(c, service, c.Param("id"))
+}
+
+// Take points from player Controller, responds with new balance
+func takeV2Controller(c *routing.Context, service Service) error {
+	points, err := readPoints(c)
+	if err != nil {
+		return err
+	}
+
+	if err := service.Take(c.Param("id"), points); err != nil {
+		return err
+	}
+	return writePlayer(c, service, c.Param("id"))
+}
+
+// Batch fund and take Controller, responds with result of every operation
+func batchV2Controller(c *routing.Context, service Service) error {
+	var body BatchRequest
+	if err := readBody(c, &body); err != nil {
+		return err
+	}
+
+	if len(body.Operations) == 0 || len(body.Operations) > maxBatchOperations {
+		return badRequest("operations must contain from 1 to " + strconv.Itoa(maxBatchOperations) + " items")
+	}
+	if body.Mode == "" {
+		body.Mode = BatchAtomic
+	}
+
+	results, err := service.Batch(body.Mode, body.Operations)
+	if err != nil {
+		return err
+	}
+
+	applied := 0
+	for _, result := range results {
+		if result.Applied {
+			applied++
+		}
+	}
+
+	return c.Write(map[string]interface{}{
+		"applied": applied,
+		"failed":  len(results) - applied,
+		"results": results,
+	})
+}
+
+// Transfer points between players Controller, responds with new balances
+func transferV2Controller(c *routing.Context, service Service) error {
+	var body TransferRequest
+	if err := readBody(c, &body); err != nil {
+		return err
+	}
+
+	if body.FromPlayerID == "" || body.ToPlayerID == "" {
+		return badRequest("fromPlayerId and toPlayerId are requred")
+	}
+	if body.Points <= 0 {
+		return badRequest("invalid points")
+	}
+
+	if err := service.Transfer(body.FromPlayerID, body.ToPlayerID, body.Points); err != nil {
+		return err
+	}
+
+	var response TransferResponse
+	var err error
+	if response.From, err = service.PlayerBalance(body.FromPlayerID); err != nil {
+		return err
+	}
+	if response.To, err = service.PlayerBalance(body.ToPlayerID); err != nil {
+		return err
+	}
+	return c.Write(response)
+}
+
+// Announce tournament Controller, responds 201 with tournament details
+func announceTournamentV2Controller(c *routing.Context, service Service) error {
+	var body TournamentRequest
+	if err := readBody(c, &body); err != nil {
+		return err
+	}
+
+	if body.TournamentID == "" {
+		return badRequest("tournamentId is requred")
+	}
+	if body.Deposit == nil {
+		return badRequest("deposit is requred")
+	}
+	openRegistration := body.OpenRegistration == nil || *body.OpenRegistration
+
+	err := service.AnnounceTournament(body.TournamentID, *body.Deposit, body.SplitPolicy, openRegistration)
+	if err != nil {
+		return err
+	}
+	return writeTournament(c, service, body.TournamentID, http.StatusCreated)
+}
+
+// Tournament details Controller
+func tournamentV2Controller(c *routing.Context, service Service) error {
+	return writeTournament(c, service, c.Param("id"), http.StatusOK)
+}
+
+// Change tournament status Controller, responds with tournament details
+func tournamentStatusV2Controller(c *routing.Context, service Service, status string) error {
+	if err := service.ChangeTournamentStatus(c.Param("id"), status); err != nil {
+		return err
+	}
+	return writeTournament(c, service, c.Param("id"), http.StatusOK)
+}
+
+// Cancel tournament Controller, responds with tournament details
+func cancelTournamentV2Controller(c *routing.Context, service Service) error {
+	if err := service.CancelTournament(c.Param("id")); err != nil {
+		return err
+	}
+	return writeTournament(c, service, c.Param("id"), http.StatusOK)
+}
+
+// Join tournament Controller, responds 201 with tournament details
+func joinTournamentV2Controller(c *routing.Context, service Service) error {
+	var body EntryRequest
+	if err := readBody(c, &body); err != nil {
+		return err
+	}
+
+	if body.PlayerID == "" {
+		return badRequest("playerId is requred")
+	}
+
+	// Stakes are set for player and every backer, or for nobody
+	var backers []string
+	var stakes []int64
+	if body.PlayerStake != nil {
+		stakes = append(stakes, *body.PlayerStake)
+	}
+	for _, backer := range body.Backers {
+		if backer.PlayerID == "" {
+			return badRequest("playerId of backer is requred")
+		}
+		backers = append(backers, backer.PlayerID)
+
+		if (backer.Stake != nil) != (body.PlayerStake != nil) {
+			return badRequest("stakes must be set for player and every backer")
+		}
+		if backer.Stake != nil {
+			stakes = append(stakes, *backer.Stake)
+		}
+	}
+
+	err := service.JoinTournament(c.Param("id"), body.PlayerID, backers, stakes)
+	if err != nil {
+		return err
+	}
+	return writeTournament(c, service, c.Param("id"), http.StatusCreated)
+}
+
+// Leave tournament Controller, responds with tournament details
+func leaveTournamentV2Controller(c *routing.Context, service Service) error {
+	if err := service.LeaveTournament(c.Param("id"), c.Param("playerId")); err != nil {
+		return err
+	}
+	return writeTournament(c, service, c.Param("id"), http.StatusOK)
+}
+
+// Result tournament Controller, body is {"winners": [{"playerId": "P1", "prize": 100}]}
+func resultTournamentV2Controller(c *routing.Context, service Service) error {
+	var body Results
+	if err := readBody(c, &body); err != nil {
+		return err
+	}
+
+	if len(body.Winners) == 0 {
+		return badRequest("bad request, empty winners")
+	}
+
+	if err := service.ResultTournament(c.Param("id"), body.Winners); err != nil {
+		return err
+	}
+	return writeTournament(c, service, c.Param("id"), http.StatusOK)
+}
+
+// API keys Controller, secrets of keys are never returned
+func apiKeysV2Controller(c *routing.Context, service Service) error {
+	keys, err := service.APIKeys()
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		keys = []APIKey{}
+	}
+	return c.Write(keys)
+}
+
+// Issue API key Controller, responds 201 with key shown only this time
+func issueAPIKeyV2Controller(c *routing.Context, service Service) error {
+	var body APIKeyRequest
+	if err := readBody(c, &body); err != nil {
+		return err
+	}
+
+	issued, err := service.IssueAPIKey(body.Role, body.Name)
+	if err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusCreated)
+	return c.Write(issued)
+}
+
+// Revoke API key Controller, responds 204
+func revokeAPIKeyV2Controller(c *routing.Context, service Service) error {
+	if err := service.RevokeAPIKey(c.Param("id")); err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIV2(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	request := func(method string, path string, body string, data interface{}) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if data != nil {
+			json.NewDecoder(res.Body).Decode(data)
+		}
+		return res
+	}
+
+	var player Players
+	res := request("POST", "/v2/players/P1/fund", `{"points": 1000}`, &player)
+	assert.Equal(t, 200, res.StatusCode, "Fund P1")
+	assert.Equal(t, int64(1000), player.Balance, "Balance after fund")
+	assert.Empty(t, res.Header.Get("Deprecation"), "v2 isn't deprecated")
+
+	request("POST", "/v2/players/P2/fund", `{"points": 1000}`, nil)
+	res = request("POST", "/v2/players/P2/take", `{"points": 500}`, &player)
+	assert.Equal(t, int64(500), player.Balance, "Balance after take")
+
+	res = request("GET", "/v2/players/P1/fund", ``, nil)
+	assert.Equal(t, 405, res.StatusCode, "Fund needs POST")
+	res = request("POST", "/v2/players/P1/fund", `{"points": -1}`, nil)
+	assert.Equal(t, 400, res.StatusCode, "Invalid points")
+
+	var details TournamentDetails
+	res = request("POST", "/v2/tournaments", `{"tournamentId": "1", "deposit": 1000}`, &details)
+	assert.Equal(t, 201, res.StatusCode, "Announce tournament")
+	assert.Equal(t, StatusRegistrationOpen, details.Status)
+
+	entry := `{"playerId": "P1", "playerStake": 600, "backers": [{"playerId": "P2", "stake": 400}]}`
+	res = request("POST", "/v2/tournaments/1/entries", entry, &details)
+	assert.Equal(t, 201, res.StatusCode, "P1 joins backed by P2")
+	assert.Len(t, details.Participants, 1)
+
+	res = request("DELETE", "/v2/tournaments/1/entries/P1", ``, &details)
+	assert.Equal(t, 200, res.StatusCode, "P1 leaves")
+	assert.Empty(t, details.Participants)
+
+	res = request("POST", "/v2/tournaments/1/entries", `{"playerId": "P1", "playerStake": 600, "backers": [{"playerId": "P2"}]}`, nil)
+	assert.Equal(t, 400, res.StatusCode, "Stake of backer is missing")
+
+	request("POST", "/v2/tournaments/1/entries", entry, nil)
+
+	// Only running tournament gets results
+	res = request("POST", "/v2/tournaments/1/results", `{"winners": [{"playerId": "P1", "prize": 2000}]}`, nil)
+	assert.Equal(t, 409, res.StatusCode, "Result tournament which isn't running")
+
+	request("POST", "/v2/tournaments/1/close", ``, nil)
+	request("POST", "/v2/tournaments/1/start", ``, &details)
+	assert.Equal(t, StatusRunning, details.Status)
+
+	res = request("POST", "/v2/tournaments/1/results", `{"winners": [{"playerId": "P1", "prize": 2000}]}`, &details)
+	assert.Equal(t, 200, res.StatusCode, "Result tournament")
+	assert.Equal(t, StatusFinished, details.Status)
+
+	request("GET", "/v2/players/P2", ``, &player)
+	assert.Equal(t, int64(100+800), player.Balance, "P2 gets prize for his stake")
+
+	// Legacy routes still work and are deprecated
+	res = request("GET", "/balance?playerId=P1", ``, &player)
+	assert.Equal(t, 200, res.StatusCode, "Legacy balance")
+	assert.Equal(t, "true", res.Header.Get("Deprecation"), "Legacy route is deprecated")
+}
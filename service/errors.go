@@ -0,0 +1,154 @@
+package main
+
+import (
+	"github.com/go-ozzo/ozzo-routing"
+	"net/http"
+)
+
+// Error of Social Tournament service with machine-readable code.
+// Service methods return these errors, controllers don't parse messages.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Domain errors
+var (
+	ErrPlayerNotFound          = &Error{"player_not_found", "player not found"}
+	ErrTournamentNotFound      = &Error{"tournament_not_found", "tournament not found"}
+	ErrTournamentExists        = &Error{"tournament_exists", "tournament already exists"}
+	ErrTournamentFinished      = &Error{"tournament_finished", "tournament is finished"}
+	ErrInsufficientFunds       = &Error{"insufficient_funds", "insufficient funds"}
+	ErrAlreadyJoined           = &Error{"already_joined", "player already joined tournament"}
+	ErrNotJoined               = &Error{"not_joined", "player didn't join tournament"}
+	ErrInvalidSplitPolicy      = &Error{"invalid_split_policy", "invalid split policy"}
+	ErrTournamentCancelled     = &Error{"tournament_cancelled", "tournament is cancelled"}
+	ErrRegistrationNotOpen     = &Error{"registration_not_open", "tournament registration is not open"}
+	ErrInvalidTransition       = &Error{"invalid_status_transition", "tournament can't move to this status"}
+	ErrInvalidStakes           = &Error{"invalid_stakes", "stakes must be set for player and every backer and sum to deposit"}
+	ErrSameTransferPlayer      = &Error{"same_transfer_player", "can't transfer points to the same player"}
+	ErrInvalidBatchMode        = &Error{"invalid_batch_mode", "batch mode must be atomic or bestEffort"}
+	ErrInvalidBatchOperation   = &Error{"invalid_batch_operation", "operation must be fund or take with playerId and positive points"}
+	ErrPlayerExists            = &Error{"player_exists", "player already exists"}
+	ErrPlayerFrozen            = &Error{"player_frozen", "player account is frozen"}
+	ErrPlayerClosed            = &Error{"player_closed", "player account is closed"}
+	ErrPlayerHasBalance        = &Error{"player_has_balance", "player account with points can't be closed"}
+	ErrInvalidPlayerTransition = &Error{"invalid_player_transition", "player account can't move to this status"}
+	ErrUnauthorized            = &Error{"unauthorized", "missing or invalid API key"}
+	ErrForbidden               = &Error{"forbidden", "API key role can't access this endpoint"}
+	ErrInvalidRole             = &Error{"invalid_role", "role must be admin or client"}
+	ErrAPIKeyNotFound          = &Error{"api_key_not_found", "API key not found"}
+	ErrResetDisabled           = &Error{"reset_disabled", "database reset is disabled in production"}
+	ErrConfirmationRequired    = &Error{"confirmation_required", "destructive operation requires X-Confirm-Token header"}
+	ErrShuttingDown            = &Error{"shutting_down", "server is shutting down"}
+	ErrBatchAborted            = &Error{"batch_aborted", "operation is not applied because another operation of atomic batch failed"}
+)
+
+// HTTP status for every domain error code.
+// Not found errors are 400 because clients relied on it before error codes.
+var errorStatuses = map[string]int{
+	ErrPlayerNotFound.Code:          http.StatusBadRequest,
+	ErrTournamentNotFound.Code:      http.StatusBadRequest,
+	ErrTournamentExists.Code:        http.StatusConflict,
+	ErrTournamentFinished.Code:      http.StatusBadRequest,
+	ErrInsufficientFunds.Code:       http.StatusBadRequest,
+	ErrAlreadyJoined.Code:           http.StatusConflict,
+	ErrNotJoined.Code:               http.StatusBadRequest,
+	ErrInvalidSplitPolicy.Code:      http.StatusBadRequest,
+	ErrInvalidStakes.Code:           http.StatusBadRequest,
+	ErrTournamentCancelled.Code:     http.StatusBadRequest,
+	ErrRegistrationNotOpen.Code:     http.StatusBadRequest,
+	ErrInvalidTransition.Code:       http.StatusConflict,
+	ErrSameTransferPlayer.Code:      http.StatusBadRequest,
+	ErrInvalidBatchMode.Code:        http.StatusBadRequest,
+	ErrInvalidBatchOperation.Code:   http.StatusBadRequest,
+	ErrPlayerExists.Code:            http.StatusConflict,
+	ErrPlayerFrozen.Code:            http.StatusBadRequest,
+	ErrPlayerClosed.Code:            http.StatusBadRequest,
+	ErrPlayerHasBalance.Code:        http.StatusConflict,
+	ErrInvalidPlayerTransition.Code: http.StatusConflict,
+	ErrUnauthorized.Code:            http.StatusUnauthorized,
+	ErrForbidden.Code:               http.StatusForbidden,
+	ErrInvalidRole.Code:             http.StatusBadRequest,
+	ErrAPIKeyNotFound.Code:          http.StatusNotFound,
+	ErrResetDisabled.Code:           http.StatusForbidden,
+	ErrConfirmationRequired.Code:    http.StatusPreconditionRequired,
+	ErrShuttingDown.Code:            http.StatusServiceUnavailable,
+	ErrBatchAborted.Code:            http.StatusConflict,
+}
+
+// Structure for error response, JSON body is
+// {"status": 400, "code": "player_not_found", "message": "player not found"}
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// StatusCode implements routing.HTTPError
+func (e *APIError) StatusCode() int {
+	return e.Status
+}
+
+// Create API error for invalid request params
+func badRequest(message string) *APIError {
+	return &APIError{http.StatusBadRequest, "bad_request", message}
+}
+
+// Convert any error returned by controllers to API error.
+// Domain errors get their status and code, other HTTP errors keep their
+// status, unknown errors are hidden behind internal_error (errorHandler logs them).
+func convertError(c *routing.Context, err error) error {
+	switch e := err.(type) {
+	case *APIError:
+		return e
+	case *Error:
+		return &APIError{errorStatus(e), e.Code, e.Message}
+	case routing.HTTPError:
+		if e.StatusCode() < http.StatusInternalServerError {
+			return &APIError{e.StatusCode(), errorCode(e.StatusCode()), e.Error()}
+		}
+	}
+
+	return &APIError{http.StatusInternalServerError, "internal_error", "internal error"}
+}
+
+// HTTP status of domain error
+func errorStatus(e *Error) int {
+	status, ok := errorStatuses[e.Code]
+	if !ok {
+		return http.StatusBadRequest
+	}
+	return status
+}
+
+// Code for HTTP errors without domain error, e.g. "not_found" for 404
+func errorCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusUnauthorized:
+		return "unauthorized"
+	case http.StatusForbidden:
+		return "forbidden"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusUnprocessableEntity:
+		return "unprocessable_entity"
+	case http.StatusPreconditionRequired:
+		return "precondition_required"
+	}
+	return "error"
+}
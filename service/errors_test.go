@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	get := func(path string) (int, APIError) {
+		var apiError APIError
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&apiError)
+		return res.StatusCode, apiError
+	}
+
+	get("/fund?playerId=P1&points=100")
+	get("/announceTournament?tournamentId=1&deposit=1000")
+
+	cases := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/fund?playerId=P1", 400, "bad_request"},
+		{"/balance?playerId=P2", 400, "player_not_found"},
+		{"/take?playerId=P1&points=500", 400, "insufficient_funds"},
+		{"/announceTournament?tournamentId=1&deposit=1000", 409, "tournament_exists"},
+		{"/joinTournament?tournamentId=2&playerId=P1", 400, "tournament_not_found"},
+		{"/joinTournament?tournamentId=1&playerId=P1", 400, "insufficient_funds"},
+		{"/joinTournament?tournamentId=1&playerId=P1&backerId=P2", 400, "insufficient_funds"},
+		{"/joinTournament?tournamentId=1&playerId=P2&backerId=P1", 400, "player_not_found"},
+		{"/unknown", 404, "not_found"},
+	}
+
+	for _, c := range cases {
+		status, apiError := get(c.path)
+		assert.Equal(t, c.status, status, fmt.Sprint("Status for ", c.path))
+		assert.Equal(t, c.status, apiError.Status, fmt.Sprint("Status in body for ", c.path))
+		assert.Equal(t, c.code, apiError.Code, fmt.Sprint("Code for ", c.path))
+	}
+}
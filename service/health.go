@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-ozzo/ozzo-routing"
+	"net/http"
+	"time"
+)
+
+// Health statuses
+const (
+	HealthOK          = "ok"
+	HealthUnavailable = "unavailable"
+)
+
+// Time for every readiness check
+const readinessTimeout = 2 * time.Second
+
+// Structure for result of one readiness check
+type HealthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Structure for health response, status is ok only if every check is ok
+type Health struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// Method for check service can serve requests: storage is reachable,
+// schema is at version of this build and server isn't draining
+func (service *Service) Readiness(ctx context.Context) Health {
+	health := Health{Status: HealthOK, Checks: map[string]HealthCheck{}}
+	check := func(name string, err error) {
+		if err != nil {
+			health.Status = HealthUnavailable
+			health.Checks[name] = HealthCheck{Status: HealthUnavailable, Error: err.Error()}
+			return
+		}
+		health.Checks[name] = HealthCheck{Status: HealthOK}
+	}
+
+	err := service.store.Ping(ctx)
+	check("database", err)
+
+	// Schema can't be checked without database
+	if err == nil {
+		version, err := service.store.SchemaVersion(ctx)
+		if err == nil && version != latestSchemaVersion() {
+			err = fmt.Errorf("schema version %d, expected %d", version, latestSchemaVersion())
+		}
+		check("schema", err)
+	} else {
+		check("schema", fmt.Errorf("database is unavailable"))
+	}
+
+	if service.Drain.Draining() {
+		check("drain", ErrShuttingDown)
+	} else {
+		check("drain", nil)
+	}
+
+	return health
+}
+
+// Liveness Controller, process is alive if it responds
+func healthzController(c *routing.Context) error {
+	return c.Write(Health{Status: HealthOK})
+}
+
+// Readiness Controller, responds 503 if any check fails
+func readyzController(c *routing.Context, service Service) error {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	health := service.Readiness(ctx)
+	if health.Status != HealthOK {
+		c.Response.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return c.Write(health)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Memory store with broken database connection or outdated schema
+type unhealthyStore struct {
+	*MemoryStore
+	pingErr error
+	version int
+}
+
+func (store *unhealthyStore) Ping(ctx context.Context) error {
+	return store.pingErr
+}
+
+func (store *unhealthyStore) SchemaVersion(ctx context.Context) (int, error) {
+	return store.version, nil
+}
+
+// Memory store which schema query hangs until it's cancelled
+type hangingSchemaStore struct {
+	*MemoryStore
+}
+
+func (store hangingSchemaStore) SchemaVersion(ctx context.Context) (int, error) {
+	<-ctx.Done()
+	return 0, ctx.Err()
+}
+
+func TestHealth(t *testing.T) {
+
+	get := func(service Service, path string, status int) Health {
+		server := httptest.NewServer(newRouter(service))
+		defer server.Close()
+
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var health Health
+		json.NewDecoder(res.Body).Decode(&health)
+		assert.Equal(t, status, res.StatusCode, path)
+		return health
+	}
+
+	ok := HealthCheck{Status: HealthOK}
+	health := get(Service{store: NewMemoryStore(), Drain: &Drain{}}, "/readyz", 200)
+	assert.Equal(t, Health{Status: HealthOK, Checks: map[string]HealthCheck{"database": ok, "schema": ok, "drain": ok}}, health)
+	assert.Equal(t, Health{Status: HealthOK}, get(Service{store: NewMemoryStore()}, "/healthz", 200))
+
+	// Health endpoints answer while draining, server isn't ready
+	drain := &Drain{}
+	drain.Start()
+	draining := Service{store: NewMemoryStore(), Drain: drain, RequireAPIKey: true}
+	health = get(draining, "/readyz", 503)
+	assert.Equal(t, HealthUnavailable, health.Status)
+	assert.Equal(t, HealthCheck{Status: HealthUnavailable, Error: "server is shutting down"}, health.Checks["drain"])
+	get(draining, "/healthz", 200)
+
+	// Broken database connection
+	broken := &unhealthyStore{NewMemoryStore(), errors.New("connection refused"), latestSchemaVersion()}
+	health = get(Service{store: broken}, "/readyz", 503)
+	assert.Equal(t, HealthCheck{Status: HealthUnavailable, Error: "connection refused"}, health.Checks["database"])
+	assert.Equal(t, HealthUnavailable, health.Checks["schema"].Status)
+	assert.Equal(t, ok, health.Checks["drain"])
+	get(Service{store: broken}, "/healthz", 200)
+
+	// Schema isn't migrated
+	outdated := &unhealthyStore{NewMemoryStore(), nil, 3}
+	health = get(Service{store: outdated}, "/readyz", 503)
+	assert.Equal(t, ok, health.Checks["database"])
+	assert.Contains(t, health.Checks["schema"].Error, "schema version 3")
+
+	// Hanging schema query is cancelled with readiness timeout
+	service := Service{store: hangingSchemaStore{NewMemoryStore()}}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	health = service.Readiness(ctx)
+	assert.True(t, time.Since(start) < time.Second, "Readiness waits for timeout only")
+	assert.Equal(t, HealthCheck{Status: HealthUnavailable, Error: context.DeadlineExceeded.Error()}, health.Checks["schema"])
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+)
+
+// Page size of player history
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 100
+)
+
+// Structure for one balance change in player history.
+// Balance is player balance right after the change.
+type HistoryEntry struct {
+	ID           int64     `db:"id" json:"id"`
+	MovementID   int64     `db:"movement_id" json:"movementId"`
+	Kind         string    `db:"kind" json:"type"`
+	Amount       int64     `db:"amount" json:"amount"`
+	Balance      int64     `db:"balance" json:"balance"`
+	TournamentID string    `db:"tournament_id" json:"tournamentId,omitempty"`
+	GameID       int64     `db:"game_id" json:"gameId,omitempty"`
+	CreatedAt    time.Time `db:"created_at" json:"createdAt"`
+}
+
+// Structure for player history filter, empty fields don't filter
+type HistoryFilter struct {
+	PlayerID    string
+	Kinds       []string
+	CreatedFrom time.Time // inclusive
+	CreatedTo   time.Time // exclusive
+	Before      int64     // id of the last entry of previous page
+	Limit       int
+}
+
+// Check if history entry matches filter
+func (filter HistoryFilter) Match(entry HistoryEntry) bool {
+	if len(filter.Kinds) > 0 {
+		found := false
+		for _, kind := range filter.Kinds {
+			found = found || kind == entry.Kind
+		}
+		if !found {
+			return false
+		}
+	}
+	if !filter.CreatedFrom.IsZero() && entry.CreatedAt.Before(filter.CreatedFrom) {
+		return false
+	}
+	if !filter.CreatedTo.IsZero() && !entry.CreatedAt.Before(filter.CreatedTo) {
+		return false
+	}
+	if filter.Before > 0 && entry.ID >= filter.Before {
+		return false
+	}
+	return true
+}
+
+// Structure for page of player history, NextCursor is empty on the last page
+type HistoryPage struct {
+	PlayerID   string         `json:"playerId"`
+	Entries    []HistoryEntry `json:"entries"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// Method for list balance changes of player, newest first, one page at a time
+func (service *Service) PlayerHistory(filter HistoryFilter) (HistoryPage, error) {
+	if filter.Limit <= 0 || filter.Limit > maxHistoryLimit {
+		filter.Limit = defaultHistoryLimit
+	}
+	limit := filter.Limit
+
+	// Load one more entry to know if there is next page
+	filter.Limit++
+
+	var entries []HistoryEntry
+	err := service.store.Transactional(func(tx StoreTx) error {
+		_, err := tx.Player(filter.PlayerID)
+		if err == sql.ErrNoRows {
+			return ErrPlayerNotFound
+		}
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "playerId", filter.PlayerID)
+			return err
+		}
+
+		entries, err = tx.PlayerHistory(filter)
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "playerId", filter.PlayerID)
+		}
+		return err
+	})
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	page := HistoryPage{PlayerID: filter.PlayerID, Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = strconv.FormatInt(entries[limit-1].ID, 10)
+	}
+	if page.Entries == nil {
+		page.Entries = []HistoryEntry{}
+	}
+	return page, nil
+}
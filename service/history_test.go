@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlayerHistory(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	history := func(query string) []HistoryEntry {
+		var entries []HistoryEntry
+		for cursor := ""; ; {
+			var page HistoryPage
+			res := get("/history?playerId=P1&limit=2&cursor=" + cursor + "&" + query)
+			assert.Equal(t, 200, res.StatusCode, "History ", query)
+			json.NewDecoder(res.Body).Decode(&page)
+			res.Body.Close()
+
+			entries = append(entries, page.Entries...)
+			if page.NextCursor == "" {
+				return entries
+			}
+			cursor = page.NextCursor
+		}
+	}
+
+	get("/fund?playerId=P1&points=300")
+	get("/fund?playerId=P2&points=300")
+	get("/take?playerId=P1&points=100")
+	get("/announceTournament?tournamentId=1&deposit=100")
+	get("/joinTournament?tournamentId=1&playerId=P2&backerId=P1")
+	body := `{"tournamentId": "1", "winners": [{"playerId": "P2", "prize": 500}]}`
+	res, err := http.Post(server.URL+"/resultTournament", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	// Newest first with running balance
+	entries := history("")
+	expected := []struct {
+		kind    string
+		amount  int64
+		balance int64
+	}{
+		{MovementPrize, 250, 400},
+		{MovementBacking, -50, 150},
+		{MovementTake, -100, 200},
+		{MovementFund, 300, 300},
+	}
+	if assert.Len(t, entries, len(expected)) {
+		for i, e := range expected {
+			assert.Equal(t, e.kind, entries[i].Kind, fmt.Sprint("Kind of entry ", i))
+			assert.Equal(t, e.amount, entries[i].Amount, fmt.Sprint("Amount of entry ", i))
+			assert.Equal(t, e.balance, entries[i].Balance, fmt.Sprint("Balance after entry ", i))
+		}
+		assert.Equal(t, "1", entries[0].TournamentID)
+	}
+
+	// Running balance doesn't depend on filter
+	entries = history("type=backing&type=take")
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, int64(150), entries[0].Balance)
+		assert.Equal(t, int64(200), entries[1].Balance)
+	}
+
+	assert.Empty(t, history("createdTo=2000-01-01T00:00:00Z"))
+
+	res = get("/history?playerId=P3")
+	assert.Equal(t, 400, res.StatusCode, "Player not found")
+	res = get("/history?playerId=P1&cursor=x")
+	assert.Equal(t, 400, res.StatusCode, "Invalid cursor")
+}
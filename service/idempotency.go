@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-ozzo/ozzo-routing"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Request which reserved a key and didn't complete it for this time is
+// abandoned (crashed process). Its operation may be committed, so the key
+// is never executed again, client has to check state and use a new key.
+const idempotencyPendingTimeout = time.Minute
+
+// Structure (Model) for result of the first execution of idempotent request.
+// Status is 0 while the first request is still in progress.
+type IdempotencyRecord struct {
+	Key         string    `db:"key"`
+	Fingerprint string    `db:"fingerprint"`
+	Status      int       `db:"status"`
+	Body        string    `db:"body"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// Method for reserve idempotency key for request with given fingerprint.
+// Returns true if key is reserved and request must be executed,
+// otherwise returns record stored by the first request with this key.
+func (service *Service) ReserveIdempotencyKey(key string, fingerprint string) (IdempotencyRecord, bool, error) {
+	var record IdempotencyRecord
+	var reserved bool
+
+	err := service.store.Transactional(func(tx StoreTx) error {
+		now := time.Now().UTC()
+		r, err := tx.InsertIdempotencyRecord(IdempotencyRecord{
+			Key:         key,
+			Fingerprint: fingerprint,
+			CreatedAt:   now,
+		})
+		if err != nil {
+			return err
+		}
+		if r == 1 {
+			reserved = true
+			return nil
+		}
+
+		// Key is already used, load it's record
+		record, err = tx.IdempotencyRecord(key)
+		return err
+	})
+	if err != nil {
+		service.Logger.Error("database error", "error", err, "idempotencyKey", key)
+	}
+
+	return record, reserved, err
+}
+
+// Method for save response of request which reserved the key
+func (service *Service) CompleteIdempotencyKey(key string, status int, body string) error {
+	err := service.store.Transactional(func(tx StoreTx) error {
+		record, err := tx.IdempotencyRecord(key)
+		if err != nil {
+			return err
+		}
+
+		record.Status = status
+		record.Body = body
+		return tx.UpdateIdempotencyRecord(record)
+	})
+	if err != nil {
+		service.Logger.Error("database error", "error", err, "idempotencyKey", key)
+	}
+
+	return err
+}
+
+// Method for release reserved key, so request can be retried
+func (service *Service) ReleaseIdempotencyKey(key string) error {
+	err := service.store.Transactional(func(tx StoreTx) error {
+		return tx.DeleteIdempotencyRecord(key)
+	})
+	if err != nil && err != sql.ErrNoRows {
+		service.Logger.Error("database error", "error", err, "idempotencyKey", key)
+		return err
+	}
+
+	return nil
+}
+
+// Calculate fingerprint of request params: method, path, query and body.
+// requestId param is not a part of fingerprint, it's the key itself.
+func requestFingerprint(c *routing.Context) (string, error) {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	// Body must be readable by controller again
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	query := c.Request.URL.Query()
+	query.Del("requestId")
+
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "?" + query.Encode() + "\n"))
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ResponseWriter which keeps status and copy of the written body
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+// Key of idempotent request store in routing context
+const idempotentStoreKey = "idempotentStore"
+
+// Store of idempotent request, it notes if request committed any changes.
+// Key of such request isn't released, even if request fails afterwards.
+type idempotentStore struct {
+	Store
+	committed bool
+}
+
+// Method for run fn in transaction and note if it committed changes
+func (store *idempotentStore) Transactional(fn func(tx StoreTx) error) error {
+	wrote := false
+	err := store.Store.Transactional(func(tx StoreTx) error {
+		return fn(idempotentTx{tx, &wrote})
+	})
+	if err == nil && wrote {
+		store.committed = true
+	}
+	return err
+}
+
+// Transaction of idempotent request, it notes calls of changing methods
+type idempotentTx struct {
+	StoreTx
+	wrote *bool
+}
+
+func (tx idempotentTx) FundPlayer(id string, points int64) error {
+	*tx.wrote = true
+	return tx.StoreTx.FundPlayer(id, points)
+}
+
+func (tx idempotentTx) TakePlayer(id string, points int64) (int64, error) {
+	*tx.wrote = true
+	return tx.StoreTx.TakePlayer(id, points)
+}
+
+func (tx idempotentTx) CreditPlayer(id string, points int64) (int64, error) {
+	*tx.wrote = true
+	return tx.StoreTx.CreditPlayer(id, points)
+}
+
+func (tx idempotentTx) InsertPlayer(player Players) error {
+	*tx.wrote = true
+	return tx.StoreTx.InsertPlayer(player)
+}
+
+func (tx idempotentTx) UpdatePlayerStatus(id string, status string) error {
+	*tx.wrote = true
+	return tx.StoreTx.UpdatePlayerStatus(id, status)
+}
+
+func (tx idempotentTx) InsertTournament(tournament Tournaments) error {
+	*tx.wrote = true
+	return tx.StoreTx.InsertTournament(tournament)
+}
+
+func (tx idempotentTx) UpdateTournamentStatus(id string, status string) error {
+	*tx.wrote = true
+	return tx.StoreTx.UpdateTournamentStatus(id, status)
+}
+
+func (tx idempotentTx) InsertGame(game Games) (int64, error) {
+	*tx.wrote = true
+	return tx.StoreTx.InsertGame(game)
+}
+
+func (tx idempotentTx) DeleteGame(tournamentID string, playerID string) error {
+	*tx.wrote = true
+	return tx.StoreTx.DeleteGame(tournamentID, playerID)
+}
+
+func (tx idempotentTx) InsertMovement(movement Movement) error {
+	*tx.wrote = true
+	return tx.StoreTx.InsertMovement(movement)
+}
+
+func (tx idempotentTx) InsertAPIKey(key APIKey) error {
+	*tx.wrote = true
+	return tx.StoreTx.InsertAPIKey(key)
+}
+
+func (tx idempotentTx) RevokeAPIKey(id string, revokedAt time.Time) error {
+	*tx.wrote = true
+	return tx.StoreTx.RevokeAPIKey(id, revokedAt)
+}
+
+// Idempotency middleware for mutating endpoints.
+// Key is taken from Idempotency-Key header or requestId param. The first
+// request with a key is executed and its response is stored, replays with
+// the same params get the stored response, replays with other params are
+// rejected. Server errors are not stored, such requests can be retried,
+// unless request committed changes before the error (e.g. reading response
+// failed), then the error is stored as its response.
+// Request which never finished is not executed again, see
+// idempotencyPendingTimeout.
+func idempotencyHandler(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		service := requestService(c, service)
+
+		key := c.Request.Header.Get("Idempotency-Key")
+		if key == "" {
+			key = c.Query("requestId")
+		}
+
+		// Request without key is executed as usual
+		if key == "" {
+			return c.Next()
+		}
+
+		fingerprint, err := requestFingerprint(c)
+		if err != nil {
+			return badRequest("bad request")
+		}
+
+		record, reserved, err := service.ReserveIdempotencyKey(key, fingerprint)
+		if err != nil {
+			return err
+		}
+
+		if !reserved {
+			// Key must be used with the same params
+			if record.Fingerprint != fingerprint {
+				return &APIError{http.StatusUnprocessableEntity, "idempotency_key_reused", "idempotency key is used for other request"}
+			}
+
+			// The first request isn't finished yet or never finished,
+			// it isn't executed again in both cases
+			if record.Status == 0 && time.Since(record.CreatedAt) > idempotencyPendingTimeout {
+				return &APIError{http.StatusConflict, "idempotency_key_abandoned", "request with this idempotency key didn't finish, its result is unknown"}
+			}
+			if record.Status == 0 {
+				return &APIError{http.StatusConflict, "idempotency_key_in_progress", "request with this idempotency key is in progress"}
+			}
+
+			// Replay response of the first request
+			c.Response.Header().Set("Content-Type", "application/json")
+			c.Response.Header().Set("Idempotent-Replayed", "true")
+			c.Response.WriteHeader(record.Status)
+			_, err = c.Response.Write([]byte(record.Body))
+			c.Abort()
+			return err
+		}
+
+		// Execute request and record its response, controllers use store
+		// which notes if request committed changes
+		recorder := &responseRecorder{ResponseWriter: c.Response, status: http.StatusOK}
+		c.Response = recorder
+		store := &idempotentStore{Store: service.store}
+		c.Set(idempotentStoreKey, store)
+
+		// Release key if controller panics before commit, nothing is stored
+		finished := false
+		defer func() {
+			c.Response = recorder.ResponseWriter
+			c.Set(idempotentStoreKey, nil)
+			if !finished && !store.committed {
+				service.ReleaseIdempotencyKey(key)
+			}
+		}()
+
+		err = c.Next()
+		finished = true
+
+		status := recorder.status
+		body := recorder.body.String()
+		if err != nil {
+			apiError := convertError(c, err).(*APIError)
+			status = apiError.Status
+			b, _ := json.Marshal(apiError)
+			body = string(b)
+		}
+
+		// Server errors are not final, request can be retried with the same key
+		// if it didn't commit anything
+		if status >= http.StatusInternalServerError && !store.committed {
+			service.ReleaseIdempotencyKey(key)
+			return err
+		}
+
+		if e := service.CompleteIdempotencyKey(key, status, body); e != nil {
+			service.Logger.Error("can't complete idempotency key", "error", e, "idempotencyKey", key)
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotentFund(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	fund := func(points string, key string) *http.Response {
+		url := fmt.Sprintf("%s/fund?playerId=P1&points=%s", server.URL, points)
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Set("Idempotency-Key", key)
+		res, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		return res
+	}
+
+	res := fund("300", "K1")
+	assert.Equal(t, 200, res.StatusCode, "Fund 300 points for P1")
+
+	// Retry is replayed, not executed again
+	res = fund("300", "K1")
+	assert.Equal(t, 200, res.StatusCode, "Retry fund 300 points for P1")
+	assert.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+
+	// Same key with other params is rejected
+	res = fund("500", "K1")
+	assert.Equal(t, 422, res.StatusCode, "Reuse key for other points")
+
+	// requestId param works as a key too
+	url := fmt.Sprintf("%s/take?playerId=P1&points=100&requestId=K2", server.URL)
+	res, _ = http.Get(url)
+	assert.Equal(t, 200, res.StatusCode, "Take 100 points from P1")
+	res, _ = http.Get(url)
+	assert.Equal(t, 200, res.StatusCode, "Retry take 100 points from P1")
+
+	res, err := http.Get(fmt.Sprintf("%s/balance?playerId=P1", server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var player Players
+	json.NewDecoder(res.Body).Decode(&player)
+	assert.Equal(t, int64(200), player.Balance, "Balance for P1")
+}
+
+func TestIdempotencyAbandonedKey(t *testing.T) {
+
+	store := NewMemoryStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+
+	fund := func() (int, APIError) {
+		req, _ := http.NewRequest("GET", server.URL+"/fund?playerId=P1&points=300", nil)
+		req.Header.Set("Idempotency-Key", "K1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var apiError APIError
+		json.NewDecoder(res.Body).Decode(&apiError)
+		return res.StatusCode, apiError
+	}
+
+	status, _ := fund()
+	assert.Equal(t, 200, status, "Fund 300 points for P1")
+
+	// Process crashed after fund was committed, response isn't stored
+	store.Transactional(func(tx StoreTx) error {
+		record, err := tx.IdempotencyRecord("K1")
+		assert.Nil(t, err)
+		record.Status = 0
+		record.Body = ""
+		record.CreatedAt = time.Now().UTC().Add(-2 * idempotencyPendingTimeout)
+		return tx.UpdateIdempotencyRecord(record)
+	})
+
+	// Retry isn't executed again
+	status, apiError := fund()
+	assert.Equal(t, 409, status, "Retry of abandoned request")
+	assert.Equal(t, "idempotency_key_abandoned", apiError.Code)
+
+	player, err := (&Service{store: store}).PlayerBalance("P1")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(300), player.Balance, "Balance for P1")
+}
+
+// Memory store which loses connection once right after points are funded
+type flakyAfterFundStore struct {
+	*MemoryStore
+	broken bool
+}
+
+func (store *flakyAfterFundStore) Transactional(fn func(tx StoreTx) error) error {
+	if store.broken {
+		store.broken = false
+		return errors.New("connection lost")
+	}
+
+	funded := false
+	err := store.MemoryStore.Transactional(func(tx StoreTx) error {
+		return fn(fundingTx{tx, &funded})
+	})
+	store.broken = err == nil && funded
+	return err
+}
+
+type fundingTx struct {
+	StoreTx
+	funded *bool
+}
+
+func (tx fundingTx) FundPlayer(id string, points int64) error {
+	*tx.funded = true
+	return tx.StoreTx.FundPlayer(id, points)
+}
+
+func TestIdempotencyKeyAfterCommit(t *testing.T) {
+
+	store := &flakyAfterFundStore{MemoryStore: NewMemoryStore()}
+	server := httptest.NewServer(newRouter(Service{store: store}))
+	defer server.Close()
+
+	fund := func() *http.Response {
+		req, _ := http.NewRequest("POST", server.URL+"/v2/players/P1/fund", strings.NewReader(`{"points": 100}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Idempotency-Key", "K1")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	// Fund is committed, reading balance for response fails
+	res := fund()
+	assert.Equal(t, 500, res.StatusCode, "Fund with failed response")
+
+	// Retry gets the same response, points are not funded again
+	res = fund()
+	assert.Equal(t, 500, res.StatusCode, "Retry fund")
+	assert.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+
+	service := Service{store: store.MemoryStore}
+	player, err := service.PlayerBalance("P1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), player.Balance, "Balance for P1")
+}
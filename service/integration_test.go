@@ -9,13 +9,14 @@ import (
 	"net/http/httptest"
 	"testing"
         "strconv"
+        "strings"
 )
 
 func TestService(t *testing.T) {
 
-	db := initDatabase()
-	defer db.Close()
-	server := httptest.NewServer(initRouter(db))
+	store, closeStore := newTestStore(t)
+	defer closeStore()
+	server := httptest.NewServer(initRouter(store))
 	defer server.Close()
 
 	log.Println("Reset DB")
@@ -98,10 +99,10 @@ func TestService(t *testing.T) {
 	assert.Equal(t, res.StatusCode, 200, "P1 joins backed by P2, P3, P4")
 
 	resultBalances := map[string]int64{
-		"P1": 550,
-		"P2": 550,
-		"P3": 550,
-		"P4": 750,
+		"P1": 50,
+		"P2": 50,
+		"P3": 50,
+		"P4": 250,
 		"P5": 0,
 	}
 
@@ -133,3 +134,48 @@ func TestService(t *testing.T) {
                 assert.Equal(t, balance, result.Balance, "Balance for ", player)
         }
 }
+
+func TestServiceResult(t *testing.T) {
+
+	store, closeStore := newTestStore(t)
+	defer closeStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+
+	request := func(method string, path string, body string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, 200, res.StatusCode, method+" "+path)
+	}
+
+	funds := map[string]int64{"P1": 300, "P2": 300, "P3": 300, "P4": 500, "P5": 1000}
+	for player, points := range funds {
+		request("GET", fmt.Sprintf("/fund?playerId=%s&points=%d", player, points), ``)
+	}
+	request("GET", "/announceTournament?tournamentId=1&deposit=1000", ``)
+	request("GET", "/joinTournament?tournamentId=1&playerId=P5", ``)
+	request("GET", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3&backerId=P4", ``)
+
+	// P1 wins 2000, prize is split between P1 and backers
+	request("POST", "/resultTournament", `{"tournamentId": "1", "winners": [{"playerId": "P1", "prize": 2000}]}`)
+
+	resultBalances := map[string]int64{"P1": 550, "P2": 550, "P3": 550, "P4": 750, "P5": 0}
+	for player, balance := range resultBalances {
+		res, err := http.Get(server.URL + "/balance?playerId=" + player)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result Players
+		json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		assert.Equal(t, balance, result.Balance, "Balance for ", player)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLeaveTournament(t *testing.T) {
+
+	store := NewMemoryStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+
+	get := func(path string) (int, APIError) {
+		var apiError APIError
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&apiError)
+		return res.StatusCode, apiError
+	}
+
+	for _, p := range []string{"P1", "P2"} {
+		get(fmt.Sprintf("/fund?playerId=%s&points=1000", p))
+	}
+	get("/announceTournament?tournamentId=1&deposit=1000")
+	get("/joinTournament?tournamentId=1&playerId=P1&backerId=P2&playerStake=700&stake=300")
+
+	status, _ := get("/leaveTournament?tournamentId=1&playerId=P1")
+	assert.Equal(t, 200, status, "P1 leaves tournament")
+
+	_, apiError := get("/leaveTournament?tournamentId=1&playerId=P1")
+	assert.Equal(t, "not_joined", apiError.Code, "P1 already left tournament")
+
+	for _, p := range []string{"P1", "P2"} {
+		player, err := (&Service{store: store}).PlayerBalance(p)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(1000), player.Balance, "Balance for ", p)
+	}
+
+	// Refund is recorded as leave movement
+	var left int64
+	for _, entry := range store.state.ledger {
+		if entry.Kind == MovementLeave && entry.Amount > 0 {
+			left += entry.Amount
+		}
+	}
+	assert.Equal(t, int64(1000), left, "Points returned on leave")
+
+	// Player can join again after leaving, but not leave after registration is closed
+	status, _ = get("/joinTournament?tournamentId=1&playerId=P1")
+	assert.Equal(t, 200, status, "P1 joins again")
+	get("/closeRegistration?tournamentId=1")
+	status, apiError = get("/leaveTournament?tournamentId=1&playerId=P1")
+	assert.Equal(t, 400, status, "Registration is closed")
+	assert.Equal(t, "registration_not_open", apiError.Code, "Registration is closed")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Kinds of balance movements recorded in ledger
+const (
+	MovementOpening  = "opening" // balance which existed before ledger
+	MovementFund     = "fund"
+	MovementTake     = "take"
+	MovementTransfer = "transfer" // points moved from one player to another
+	MovementDeposit  = "deposit"  // player pays entry deposit
+	MovementBacking  = "backing"  // backer pays his share of deposit
+	MovementPrize    = "prize"
+	MovementHouse    = "house"  // remainder of split covered by or paid to house
+	MovementRefund   = "refund" // deposit returned when tournament is cancelled
+	MovementLeave    = "leave"  // deposit returned when player leaves tournament
+)
+
+// Ledger accounts.
+// Points come into the system from external account (fund) and go back
+// to it (take), tournament account holds deposits until prizes are paid.
+// House account collects prize remainders and covers deposit remainders.
+// Suspense account holds refunds and prizes of closed players until they
+// are settled outside of the service.
+const (
+	externalAccount         = "external"
+	houseAccount            = "house"
+	suspenseAccount         = "suspense"
+	playerAccountPrefix     = "player:"
+	tournamentAccountPrefix = "tournament:"
+)
+
+func playerAccount(id string) string {
+	return playerAccountPrefix + id
+}
+
+func tournamentAccount(id string) string {
+	return tournamentAccountPrefix + id
+}
+
+// Structure (Model) for one side (debit or credit) of a movement.
+// Amount is negative for debit and positive for credit.
+type LedgerEntry struct {
+	ID           int64     `db:"id" json:"id"`
+	MovementID   int64     `db:"movement_id" json:"movementId"`
+	Kind         string    `db:"kind" json:"kind"`
+	Account      string    `db:"account" json:"account"`
+	Amount       int64     `db:"amount" json:"amount"`
+	TournamentID string    `db:"tournament_id" json:"tournamentId,omitempty"`
+	GameID       int64     `db:"game_id" json:"gameId,omitempty"`
+	CreatedAt    time.Time `db:"created_at" json:"createdAt"`
+}
+
+// Structure for balance movement, sum of entries amounts is always 0
+type Movement struct {
+	Kind         string
+	TournamentID string
+	GameID       int64
+	Entries      []LedgerEntry
+}
+
+// Create movement of points from one account to another
+func newMovement(kind string, from string, to string, points int64) Movement {
+	return Movement{
+		Kind: kind,
+		Entries: []LedgerEntry{
+			{Account: from, Amount: -points},
+			{Account: to, Amount: points},
+		},
+	}
+}
+
+// Method for record movement in ledger, refuses unbalanced movements
+func recordMovement(tx StoreTx, movement Movement) error {
+	var sum int64
+	for _, entry := range movement.Entries {
+		sum += entry.Amount
+	}
+	if sum != 0 {
+		return errors.New("unbalanced movement")
+	}
+
+	// Nothing moved, nothing to record
+	if len(movement.Entries) == 0 || movement.Entries[0].Amount == 0 {
+		return nil
+	}
+
+	return tx.InsertMovement(movement)
+}
+
+// Structure for reconciliation of player balance with ledger
+type Reconciliation struct {
+	PlayerID      string `json:"playerId"`
+	Balance       int64  `json:"balance"`
+	LedgerBalance int64  `json:"ledgerBalance"`
+	Matched       bool   `json:"matched"`
+}
+
+// Method for compare every player balance with the sum of his ledger entries
+func (service *Service) Reconcile() ([]Reconciliation, error) {
+	var result []Reconciliation
+
+	err := service.store.Transactional(func(tx StoreTx) error {
+		players, err := tx.Players()
+		if err != nil {
+			return err
+		}
+
+		balances, err := tx.LedgerBalances()
+		if err != nil {
+			return err
+		}
+
+		for _, player := range players {
+			ledgerBalance := balances[playerAccount(player.ID)]
+			delete(balances, playerAccount(player.ID))
+
+			result = append(result, Reconciliation{
+				PlayerID:      player.ID,
+				Balance:       player.Balance,
+				LedgerBalance: ledgerBalance,
+				Matched:       ledgerBalance == player.Balance,
+			})
+		}
+
+		// Ledger accounts of players which don't exist anymore
+		for account, ledgerBalance := range balances {
+			if !strings.HasPrefix(account, playerAccountPrefix) {
+				continue
+			}
+			result = append(result, Reconciliation{
+				PlayerID:      strings.TrimPrefix(account, playerAccountPrefix),
+				LedgerBalance: ledgerBalance,
+				Matched:       ledgerBalance == 0,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		service.Logger.Error("database error", "error", err)
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].PlayerID < result[j].PlayerID })
+	return result, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLedgerReconcile(t *testing.T) {
+
+	store := NewMemoryStore()
+	service := Service{store: store}
+
+	service.Fund("P1", 300)
+	service.Fund("P2", 300)
+	service.Take("P2", 50)
+	service.AnnounceTournament("1", 400, "", true)
+	assert.Nil(t, service.JoinTournament("1", "P1", []string{"P2"}, nil))
+	assert.Nil(t, service.ResultLegacyTournament("1", []Winner{{PlayerId: "P1", Prize: 600}}))
+
+	// Every player balance is explained by ledger
+	players, err := service.Reconcile()
+	assert.Nil(t, err)
+	assert.Len(t, players, 2)
+	for _, player := range players {
+		assert.True(t, player.Matched, "Ledger balance for ", player.PlayerID)
+	}
+
+	// Every movement is balanced and tournament paid more than collected
+	store.Transactional(func(tx StoreTx) error {
+		balances, err := tx.LedgerBalances()
+		assert.Nil(t, err)
+
+		var sum int64
+		for _, balance := range balances {
+			sum += balance
+		}
+		assert.Equal(t, int64(0), sum, "Sum of all ledger entries")
+		assert.Equal(t, int64(-200), balances[tournamentAccount("1")], "Tournament account")
+		assert.Equal(t, int64(-550), balances[externalAccount], "External account")
+		return nil
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// Tournament statuses
+const (
+	StatusAnnounced          = "announced"
+	StatusRegistrationOpen   = "registration_open"
+	StatusRegistrationClosed = "registration_closed"
+	StatusRunning            = "running"
+	StatusFinished           = "finished"
+	StatusCancelled          = "cancelled"
+)
+
+// Allowed transitions between tournament statuses.
+// Finished and cancelled tournaments can't change status anymore.
+// Only running tournament gets results, legacy /resultTournament is the
+// exception, see ResultLegacyTournament.
+var statusTransitions = map[string][]string{
+	StatusAnnounced:          {StatusRegistrationOpen, StatusCancelled},
+	StatusRegistrationOpen:   {StatusRegistrationClosed, StatusCancelled},
+	StatusRegistrationClosed: {StatusRunning, StatusCancelled},
+	StatusRunning:            {StatusFinished, StatusCancelled},
+}
+
+// Check if tournament can move from one status to another
+func canChangeStatus(from string, to string) bool {
+	for _, status := range statusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Error for operation which is not allowed in current status
+func statusError(status string) error {
+	switch status {
+	case StatusFinished:
+		return ErrTournamentFinished
+	case StatusCancelled:
+		return ErrTournamentCancelled
+	}
+	return ErrInvalidTransition
+}
+
+// Load tournament and check it can move to status. Tournament is locked
+// until the end of transaction, so concurrent changes see the new status.
+func loadTournamentFor(tx StoreTx, id string, status string) (Tournaments, error) {
+	tournament, err := tx.LockTournament(id, LockForUpdate)
+	if err == sql.ErrNoRows {
+		return tournament, ErrTournamentNotFound
+	}
+	if err != nil {
+		return tournament, err
+	}
+
+	if !canChangeStatus(tournament.Status, status) {
+		return tournament, statusError(tournament.Status)
+	}
+
+	return tournament, nil
+}
+
+// Load tournament which registration is open and lock it until the end of
+// transaction, joins lock it for share, leaves for update
+func loadOpenTournament(tx StoreTx, id string, lock LockMode) (Tournaments, error) {
+	tournament, err := tx.LockTournament(id, lock)
+	if err == sql.ErrNoRows {
+		return tournament, ErrTournamentNotFound
+	}
+	if err != nil {
+		return tournament, err
+	}
+
+	if tournament.Status != StatusRegistrationOpen {
+		if err := statusError(tournament.Status); err != ErrInvalidTransition {
+			return tournament, err
+		}
+		return tournament, ErrRegistrationNotOpen
+	}
+
+	return tournament, nil
+}
+
+// Method for move tournament to new status.
+// Tournament is finished only with results, see ResultTournament,
+// and cancelled only with refunds, see CancelTournament.
+func (service *Service) ChangeTournamentStatus(id string, status string) error {
+	if status == StatusFinished || status == StatusCancelled {
+		return ErrInvalidTransition
+	}
+
+	return service.store.Transactional(func(tx StoreTx) error {
+		if _, err := loadTournamentFor(tx, id, status); err != nil {
+			return err
+		}
+
+		if err := tx.UpdateTournamentStatus(id, status); err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id, "status", status)
+			return err
+		}
+		return nil
+	})
+}
+
+// Method for cancel tournament and refund deposits.
+// Every player and backer gets back exactly the points recorded on join,
+// the part of deposit covered by house goes back to house.
+// Cancelling already cancelled tournament does nothing, so it's safe to retry.
+func (service *Service) CancelTournament(id string) error {
+	return service.store.Transactional(func(tx StoreTx) error {
+		tournament, err := loadTournamentFor(tx, id, StatusCancelled)
+		if err == ErrTournamentCancelled {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.UpdateTournamentStatus(id, StatusCancelled); err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id)
+			return err
+		}
+
+		games, err := tx.Games(id)
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id)
+			return err
+		}
+
+		for _, game := range games {
+			if err := refundGame(tx, tournament, game, MovementRefund); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Return points paid on join to player and backers of the game,
+// movements are recorded with given kind
+func refundGame(tx StoreTx, tournament Tournaments, game Games, kind string) error {
+	participants := append([]string{game.PlayerID}, game.Backers...)
+	paid, house := gameContributions(tournament, game)
+
+	for i, p := range participants {
+		account, err := payoutPoints(tx, p, paid[i])
+		if err != nil {
+			return err
+		}
+
+		movement := newMovement(kind, tournamentAccount(tournament.ID), account, paid[i])
+		movement.TournamentID = tournament.ID
+		movement.GameID = game.ID
+		if err := recordMovement(tx, movement); err != nil {
+			return err
+		}
+	}
+
+	// House gets back remainder it covered
+	movement := newMovement(kind, tournamentAccount(tournament.ID), houseAccount, house)
+	movement.TournamentID = tournament.ID
+	movement.GameID = game.ID
+	return recordMovement(tx, movement)
+}
+
+// Points paid on join by player and backers of the game and the part of
+// deposit covered by house. Games joined before stakes were recorded paid
+// equal parts of deposit, the remainder wasn't taken from anyone.
+func gameContributions(tournament Tournaments, game Games) ([]int64, int64) {
+	participants := 1 + len(game.Backers)
+	if len(game.Stakes) != participants {
+		paid := make([]int64, participants)
+		for i := range paid {
+			paid[i] = tournament.Deposit / int64(participants)
+		}
+		return paid, 0
+	}
+
+	house := tournament.Deposit
+	for _, points := range game.Stakes {
+		house -= points
+	}
+	return game.Stakes, house
+}
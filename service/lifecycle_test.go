@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTournamentLifecycle(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	check := func(res *http.Response, status int, code string, message string) {
+		defer res.Body.Close()
+		assert.Equal(t, status, res.StatusCode, message)
+		if code != "" {
+			var apiError APIError
+			json.NewDecoder(res.Body).Decode(&apiError)
+			assert.Equal(t, code, apiError.Code, message)
+		}
+	}
+
+	get := func(path string, status int, code string) {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(res, status, code, fmt.Sprint("Request ", path))
+	}
+
+	result := func(status int, code string) {
+		body := `{"tournamentId": "1", "winners": [{"playerId": "P1", "prize": 1000}]}`
+		res, err := http.Post(server.URL+"/resultTournament", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(res, status, code, "Result tournament")
+	}
+
+	get("/fund?playerId=P1&points=1000", 200, "")
+	get("/announceTournament?tournamentId=1&deposit=1000&openRegistration=false", 200, "")
+
+	// Registration is not open yet
+	get("/joinTournament?tournamentId=1&playerId=P1", 400, "registration_not_open")
+	get("/closeRegistration?tournamentId=1", 409, "invalid_status_transition")
+
+	get("/openRegistration?tournamentId=1", 200, "")
+	get("/joinTournament?tournamentId=1&playerId=P1", 200, "")
+	get("/closeRegistration?tournamentId=1", 200, "")
+
+	// Registration is closed
+	get("/fund?playerId=P2&points=1000", 200, "")
+	get("/joinTournament?tournamentId=1&playerId=P2", 400, "registration_not_open")
+
+	get("/startTournament?tournamentId=1", 200, "")
+	get("/openRegistration?tournamentId=1", 409, "invalid_status_transition")
+
+	result(200, "")
+
+	// Finished tournament can't change status
+	result(400, "tournament_finished")
+	get("/cancelTournament?tournamentId=1", 400, "tournament_finished")
+	get("/startTournament?tournamentId=2", 400, "tournament_not_found")
+
+	// Announced tournament can be cancelled
+	get("/announceTournament?tournamentId=2&deposit=1000&openRegistration=false", 200, "")
+	get("/cancelTournament?tournamentId=2", 200, "")
+	get("/openRegistration?tournamentId=2", 400, "tournament_cancelled")
+}
+
+func TestTournamentLifecycleConcurrently(t *testing.T) {
+
+	store, closeStore := newTestStore(t)
+	defer closeStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+
+	get := func(path string) int {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	result := func() int {
+		body := `{"tournamentId": "1", "winners": [{"playerId": "P1", "prize": 1000}]}`
+		res, err := http.Post(server.URL+"/resultTournament", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+			return 0
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	balance := func(player string) int64 {
+		var p Players
+		res, err := http.Get(server.URL + "/balance?playerId=" + player)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&p)
+		return p.Balance
+	}
+
+	players := []string{"P1", "P2", "P3", "P4", "P5"}
+	for _, p := range players {
+		get(fmt.Sprintf("/fund?playerId=%s&points=1000", p))
+	}
+
+	// Concurrent results pay the prize once
+	get("/announceTournament?tournamentId=1&deposit=1000")
+	get("/joinTournament?tournamentId=1&playerId=P1")
+
+	var wg sync.WaitGroup
+	statuses := make([]int, 5)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i] = result()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.ElementsMatch(t, []int{200, 400, 400, 400, 400}, statuses, "Tournament is finished once")
+	assert.Equal(t, int64(1000), balance("P1"), "Balance for P1")
+
+	// Joins concurrent with cancel are refunded or rejected
+	get("/announceTournament?tournamentId=2&deposit=500")
+	for _, p := range players {
+		wg.Add(1)
+		go func(p string) {
+			defer wg.Done()
+			get("/joinTournament?tournamentId=2&playerId=" + p)
+		}(p)
+	}
+	assert.Equal(t, 200, get("/cancelTournament?tournamentId=2"), "Tournament is cancelled")
+	wg.Wait()
+
+	for _, p := range players {
+		assert.Equal(t, int64(1000), balance(p), "Balance for ", p)
+	}
+
+	store.Transactional(func(tx StoreTx) error {
+		balances, err := tx.LedgerBalances()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(0), balances[tournamentAccount("2")], "Tournament balance")
+		return nil
+	})
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-ozzo/ozzo-routing"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger writes one JSON object per line with time, level, message and
+// fields, e.g. {"level":"error","msg":"database error","requestId":"..."}.
+// Nil logger writes to stderr at info level, so services created without
+// logger log as usual.
+type Logger struct {
+	out    *lockedWriter
+	level  int
+	fields []interface{}
+}
+
+// Writer shared by logger and its children
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Log levels
+const (
+	LogDebug = "debug"
+	LogInfo  = "info"
+	LogWarn  = "warn"
+	LogError = "error"
+)
+
+// Rank of every log level, config accepts only these levels
+var logLevels = map[string]int{LogDebug: 0, LogInfo: 1, LogWarn: 2, LogError: 3}
+
+// Logger of service without configured logger
+var defaultLogger = NewLogger(os.Stderr, LogInfo)
+
+// Create logger which writes messages of level and above to out
+func NewLogger(out io.Writer, level string) *Logger {
+	return &Logger{out: &lockedWriter{w: out}, level: logLevels[level]}
+}
+
+func (l *Logger) get() *Logger {
+	if l == nil {
+		return defaultLogger
+	}
+	return l
+}
+
+// Logger with fields added to every message, fields are key and value pairs
+func (l *Logger) With(fields ...interface{}) *Logger {
+	l = l.get()
+	return &Logger{
+		out:    l.out,
+		level:  l.level,
+		fields: append(append([]interface{}{}, l.fields...), fields...),
+	}
+}
+
+// Check if messages of level are written
+func (l *Logger) Enabled(level string) bool {
+	return logLevels[level] >= l.get().level
+}
+
+func (l *Logger) Debug(msg string, fields ...interface{}) { l.write(LogDebug, msg, fields) }
+func (l *Logger) Info(msg string, fields ...interface{})  { l.write(LogInfo, msg, fields) }
+func (l *Logger) Warn(msg string, fields ...interface{})  { l.write(LogWarn, msg, fields) }
+func (l *Logger) Error(msg string, fields ...interface{}) { l.write(LogError, msg, fields) }
+
+func (l *Logger) write(level string, msg string, fields []interface{}) {
+	l = l.get()
+	if !l.Enabled(level) {
+		return
+	}
+
+	entry := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"level": level,
+		"msg":   msg,
+	}
+	all := append(append([]interface{}{}, l.fields...), fields...)
+	for i := 0; i+1 < len(all); i += 2 {
+		value := all[i+1]
+		// Errors are not JSON objects, their message is logged
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		entry[fmt.Sprint(all[i])] = value
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{"level": LogError, "msg": "can't encode log entry", "error": err.Error()})
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(append(data, '\n'))
+}
+
+// Writer for standard log package, every line is info message.
+// Messages logged outside requests (startup, migrations) are JSON too.
+func (l *Logger) Writer() io.Writer {
+	return logWriter{l}
+}
+
+type logWriter struct {
+	logger *Logger
+}
+
+func (w logWriter) Write(data []byte) (int, error) {
+	w.logger.Info(strings.TrimSpace(string(data)))
+	return len(data), nil
+}
+
+// Key of request logger in routing context
+const loggerKey = "logger"
+
+// Maximum length of X-Request-ID accepted from client
+const maxRequestIDLength = 128
+
+// Request ID from X-Request-ID header, new ID is generated
+// if header is missing or isn't printable ASCII
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	valid := id != "" && len(id) <= maxRequestIDLength
+	for i := 0; valid && i < len(id); i++ {
+		valid = id[i] > ' ' && id[i] < 0x7f
+	}
+	if valid {
+		return id
+	}
+
+	id, err := randomHex(16)
+	if err != nil {
+		return "unknown"
+	}
+	return id
+}
+
+// Size of request body read for log fields
+const maxLoggedBodyBytes = 64 << 10
+
+// Number of players logged with request, the rest are only counted
+const maxLoggedPlayers = 10
+
+// Request body with the part read for log fields put back
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Structure for players and tournament of JSON body,
+// e.g. of /resultTournament, /v2/transfers and /v2/batch
+type requestBodyFields struct {
+	TournamentID string `json:"tournamentId"`
+	PlayerID     string `json:"playerId"`
+	FromPlayerID string `json:"fromPlayerId"`
+	ToPlayerID   string `json:"toPlayerId"`
+	Winners      []struct {
+		PlayerID string `json:"playerId"`
+	} `json:"winners"`
+	Operations []struct {
+		PlayerID string `json:"playerId"`
+	} `json:"operations"`
+}
+
+// Player and tournament of request from query, v2 URL,
+// e.g. /v2/tournaments/1/entries/P1, or JSON body.
+// Request with several players is logged with playerIds (the first
+// maxLoggedPlayers of them) and playerCount.
+func requestFields(r *http.Request) []interface{} {
+	query := r.URL.Query()
+	players := []string{query.Get("playerId")}
+	tournament := query.Get("tournamentId")
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) >= 3 && parts[0] == "v2" {
+		switch parts[1] {
+		case "players":
+			players = append(players, parts[2])
+		case "tournaments":
+			tournament = parts[2]
+			if len(parts) == 5 && parts[3] == "entries" {
+				players = append(players, parts[4])
+			}
+		}
+	}
+
+	// Invalid body is reported by controller, fields are taken if they can be.
+	// Only the first maxLoggedBodyBytes are read, larger body isn't parsed.
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxLoggedBodyBytes+1))
+		// Body must be readable by controller again
+		r.Body = peekedBody{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+
+		var fields requestBodyFields
+		if err == nil && len(body) > 0 && len(body) <= maxLoggedBodyBytes {
+			json.Unmarshal(body, &fields)
+		}
+		if tournament == "" {
+			tournament = fields.TournamentID
+		}
+		players = append(players, fields.PlayerID, fields.FromPlayerID, fields.ToPlayerID)
+		for _, winner := range fields.Winners {
+			players = append(players, winner.PlayerID)
+		}
+		for _, op := range fields.Operations {
+			players = append(players, op.PlayerID)
+		}
+	}
+
+	// Every player once, in order of request
+	var ids []string
+	seen := map[string]bool{}
+	for _, player := range players {
+		if player != "" && !seen[player] {
+			seen[player] = true
+			ids = append(ids, player)
+		}
+	}
+
+	var fields []interface{}
+	switch len(ids) {
+	case 0:
+	case 1:
+		fields = append(fields, "playerId", ids[0])
+	default:
+		count := len(ids)
+		if count > maxLoggedPlayers {
+			ids = ids[:maxLoggedPlayers]
+		}
+		fields = append(fields, "playerIds", ids, "playerCount", count)
+	}
+	if tournament != "" {
+		fields = append(fields, "tournamentId", tournament)
+	}
+	return fields
+}
+
+// Middleware for tag request with ID and log it. ID is taken from
+// X-Request-ID header or generated and returned in X-Request-ID header,
+// every message of request is logged with it, see requestLogger.
+func requestHandler(logger *Logger) routing.Handler {
+	return func(c *routing.Context) error {
+		start := time.Now()
+		id := requestID(c.Request)
+		c.Response.Header().Set("X-Request-ID", id)
+
+		tagged := logger.With(append([]interface{}{"requestId", id}, requestFields(c.Request)...)...)
+		c.Set(loggerKey, tagged)
+
+		writer := &statusWriter{ResponseWriter: c.Response}
+		c.Response = writer
+
+		err := c.Next()
+
+		status := writer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		tagged.Info("request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"durationMs", float64(time.Since(start).Nanoseconds())/1e6,
+			"remoteAddr", c.Request.RemoteAddr,
+		)
+		return err
+	}
+}
+
+// Logger of request with request ID and fields
+func requestLogger(c *routing.Context) *Logger {
+	if logger, ok := c.Get(loggerKey).(*Logger); ok {
+		return logger
+	}
+	return defaultLogger
+}
+
+// Service for request, its messages are logged with request ID.
+// Idempotent request uses its own store, see idempotencyHandler.
+func requestService(c *routing.Context, service Service) Service {
+	if store, ok := c.Get(idempotentStoreKey).(*idempotentStore); ok {
+		service.store = store
+	}
+	service.Logger = requestLogger(c)
+	return service
+}
+
+// Middleware for convert errors and panics of handlers to API errors.
+// Server errors are logged with the cause hidden from clients,
+// other errors are logged at info level.
+func errorHandler(c *routing.Context) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			requestLogger(c).Error("panic", "panic", fmt.Sprint(e), "stack", string(debug.Stack()))
+			err = writeAPIError(c, fmt.Errorf("panic: %v", e))
+		}
+	}()
+
+	if err := c.Next(); err != nil {
+		return writeAPIError(c, err)
+	}
+	return nil
+}
+
+// Log error and write it as API error
+func writeAPIError(c *routing.Context, err error) error {
+	apiError := convertError(c, err).(*APIError)
+	if apiError.Status >= http.StatusInternalServerError {
+		requestLogger(c).Error("internal error", "error", err)
+	} else {
+		requestLogger(c).Info("request failed", "code", apiError.Code, "error", err)
+	}
+
+	c.Response.WriteHeader(apiError.Status)
+	c.Write(apiError)
+	c.Abort()
+	return nil
+}
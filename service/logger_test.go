@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Buffer safe for server goroutines and test
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(data []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(data)
+}
+
+// Log entries with message
+func (b *logBuffer) entries(t *testing.T, msg string) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line isn't JSON: %s", line)
+		}
+		if entry["msg"] == msg {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestRequestLogging(t *testing.T) {
+
+	logs := &logBuffer{}
+	server := httptest.NewServer(newRouter(Service{store: NewMemoryStore(), Logger: NewLogger(logs, LogDebug)}))
+	defer server.Close()
+
+	request := func(method string, path string, body string, requestID string, status int) string {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		assert.Equal(t, status, res.StatusCode, method+" "+path)
+		return res.Header.Get("X-Request-ID")
+	}
+
+	// Request ID of client is returned and logged
+	id := request("GET", "/fund?playerId=P1&points=100", ``, "req-1", 200)
+	assert.Equal(t, "req-1", id)
+
+	entries := logs.entries(t, "request")
+	if assert.Len(t, entries, 1) {
+		entry := entries[0]
+		assert.Equal(t, "info", entry["level"])
+		assert.Equal(t, "req-1", entry["requestId"])
+		assert.Equal(t, "P1", entry["playerId"])
+		assert.Equal(t, "GET", entry["method"])
+		assert.Equal(t, "/fund", entry["path"])
+		assert.Equal(t, float64(200), entry["status"])
+		assert.NotEmpty(t, entry["time"])
+		assert.Contains(t, entry, "durationMs")
+	}
+
+	// Request ID is generated without header or with invalid header
+	generated := request("GET", "/balance?playerId=P1", ``, "", 200)
+	assert.Len(t, generated, 32)
+	invalid := request("GET", "/balance?playerId=P1", ``, "bad id", 200)
+	assert.Len(t, invalid, 32)
+	assert.NotEqual(t, generated, invalid)
+
+	// Failed request is logged with code, player and tournament of v2 URL
+	request("POST", "/v2/tournaments", `{"tournamentId": "T1", "deposit": 500}`, "", 201)
+	request("POST", "/v2/tournaments/T1/entries", `{"playerId": "P1"}`, "req-2", 400)
+
+	entries = logs.entries(t, "request failed")
+	if assert.Len(t, entries, 1) {
+		entry := entries[0]
+		assert.Equal(t, "info", entry["level"])
+		assert.Equal(t, "req-2", entry["requestId"])
+		assert.Equal(t, "T1", entry["tournamentId"])
+		assert.Equal(t, "insufficient_funds", entry["code"])
+		assert.Equal(t, "insufficient funds", entry["error"])
+	}
+}
+
+// Memory store which fails to take points of one player like broken database
+type failingTakeStore struct {
+	*MemoryStore
+	player string
+}
+
+func (store failingTakeStore) Transactional(fn func(tx StoreTx) error) error {
+	return store.MemoryStore.Transactional(func(tx StoreTx) error {
+		return fn(failingTakeTx{tx, store.player})
+	})
+}
+
+type failingTakeTx struct {
+	StoreTx
+	player string
+}
+
+func (tx failingTakeTx) TakePlayer(id string, points int64) (int64, error) {
+	if id == tx.player {
+		return 0, errors.New("connection reset")
+	}
+	return tx.StoreTx.TakePlayer(id, points)
+}
+
+func TestRequestLoggingBodyFields(t *testing.T) {
+
+	logs := &logBuffer{}
+	server := httptest.NewServer(newRouter(Service{store: failingTakeStore{NewMemoryStore(), "P3"}, Logger: NewLogger(logs, LogDebug)}))
+	defer server.Close()
+
+	request := func(method string, path string, body string, requestID string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Request-ID", requestID)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+	}
+
+	request("GET", "/fund?playerId=P1&points=100", ``, "fund")
+	request("GET", "/fund?playerId=P2&points=100", ``, "fund")
+	request("GET", "/announceTournament?tournamentId=1&deposit=100", ``, "announce")
+	request("GET", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2", ``, "join")
+
+	// Players and tournament of JSON body are logged, controller still reads body
+	request("POST", "/resultTournament", `{"tournamentId": "1", "winners": [{"playerId": "P1", "prize": 200}]}`, "result")
+	request("POST", "/v2/transfers", `{"fromPlayerId": "P1", "toPlayerId": "P2", "points": 10}`, "transfer")
+	request("POST", "/v2/batch", `{"mode": "bestEffort", "operations": [
+		{"op": "fund", "playerId": "P2", "points": 10},
+		{"op": "fund", "playerId": "P3", "points": 10},
+		{"op": "fund", "playerId": "P2", "points": 10}
+	]}`, "batch")
+
+	fields := map[string]map[string]interface{}{}
+	for _, entry := range logs.entries(t, "request") {
+		fields[entry["requestId"].(string)] = entry
+	}
+	assert.Equal(t, "1", fields["result"]["tournamentId"])
+	assert.Equal(t, "P1", fields["result"]["playerId"])
+	assert.Equal(t, float64(200), fields["result"]["status"], "Result is applied")
+	assert.Equal(t, []interface{}{"P1", "P2"}, fields["transfer"]["playerIds"])
+	assert.Equal(t, float64(200), fields["transfer"]["status"], "Transfer is applied")
+	assert.Equal(t, []interface{}{"P2", "P3"}, fields["batch"]["playerIds"])
+	assert.Equal(t, float64(2), fields["batch"]["playerCount"])
+
+	// Only the first players of request are listed, all are counted
+	var ops []string
+	for i := 0; i < 15; i++ {
+		ops = append(ops, fmt.Sprintf(`{"op": "fund", "playerId": "P%d", "points": 1}`, i))
+	}
+	request("POST", "/v2/batch", `{"mode": "bestEffort", "operations": [`+strings.Join(ops, ",")+`]}`, "many")
+	for _, entry := range logs.entries(t, "request") {
+		if entry["requestId"] == "many" {
+			assert.Len(t, entry["playerIds"], maxLoggedPlayers)
+			assert.Equal(t, float64(15), entry["playerCount"])
+		}
+	}
+
+	// Large body isn't read for fields, controller gets all of it
+	var operations []string
+	for i := 0; i < 2000; i++ {
+		operations = append(operations, fmt.Sprintf(`{"op": "fund", "playerId": "P%d", "points": 1}`, i))
+	}
+	body := `{"mode": "bestEffort", "operations": [` + strings.Join(operations, ",") + `]}`
+	assert.True(t, len(body) > maxLoggedBodyBytes)
+	request("POST", "/v2/batch", body, "large")
+
+	entries := logs.entries(t, "request")
+	large := entries[len(entries)-1]
+	assert.Equal(t, "large", large["requestId"])
+	assert.Equal(t, float64(200), large["status"], "Large batch is applied")
+	assert.NotContains(t, large, "playerIds")
+
+	// Database error of take is logged with player and points
+	request("GET", "/take?playerId=P3&points=10", ``, "take")
+
+	entries = logs.entries(t, "database error")
+	if assert.Len(t, entries, 1) {
+		entry := entries[0]
+		assert.Equal(t, "error", entry["level"])
+		assert.Equal(t, "take", entry["requestId"])
+		assert.Equal(t, "P3", entry["playerId"])
+		assert.Equal(t, float64(10), entry["points"])
+		assert.Equal(t, "connection reset", entry["error"])
+	}
+
+	// Database error of transfer is logged with both players
+	request("GET", "/fund?playerId=P3&points=100", ``, "fund")
+	request("POST", "/v2/transfers", `{"fromPlayerId": "P3", "toPlayerId": "P1", "points": 10}`, "failedTransfer")
+
+	entries = logs.entries(t, "database error")
+	if assert.Len(t, entries, 2) {
+		entry := entries[1]
+		assert.Equal(t, "failedTransfer", entry["requestId"])
+		assert.Equal(t, "P3", entry["fromPlayerId"])
+		assert.Equal(t, "P1", entry["toPlayerId"])
+		assert.Equal(t, float64(10), entry["points"])
+		assert.Equal(t, "connection reset", entry["error"])
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+
+	logs := &logBuffer{}
+	logger := NewLogger(logs, LogWarn).With("component", "test")
+
+	logger.Debug("debug message")
+	logger.Info("info message")
+	logger.Warn("warn message", "key", "value")
+	logger.Error("error message", "error", ErrPlayerNotFound)
+
+	assert.False(t, logger.Enabled(LogInfo))
+	assert.True(t, logger.Enabled(LogError))
+	assert.Len(t, logs.entries(t, "debug message"), 0)
+	assert.Len(t, logs.entries(t, "info message"), 0)
+
+	warn := logs.entries(t, "warn message")
+	if assert.Len(t, warn, 1) {
+		assert.Equal(t, "warn", warn[0]["level"])
+		assert.Equal(t, "test", warn[0]["component"])
+		assert.Equal(t, "value", warn[0]["key"])
+	}
+
+	failed := logs.entries(t, "error message")
+	if assert.Len(t, failed, 1) {
+		assert.Equal(t, "error", failed[0]["level"])
+		assert.Equal(t, "player not found", failed[0]["error"])
+	}
+}
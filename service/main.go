@@ -1,91 +1,274 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
 	"github.com/go-ozzo/ozzo-dbx"
 	"github.com/go-ozzo/ozzo-routing"
-	"github.com/go-ozzo/ozzo-routing/access"
 	"github.com/go-ozzo/ozzo-routing/content"
-	"github.com/go-ozzo/ozzo-routing/fault"
 	"github.com/go-ozzo/ozzo-routing/slash"
 	_ "github.com/lib/pq"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
-	"time"
 )
 
-func initDatabase() *dbx.DB {
-        dsn := os.Getenv("SQL_DB")
+func initDatabase(config DatabaseConfig) *dbx.DB {
 
-        // PostgreSQL
-        db, err := dbx.MustOpen("postgres", dsn)
-        if err != nil {
-                log.Fatal(err)
-                log.Println("Connection to DB failed, aborting...")
-        }
+	// PostgreSQL
+	db, err := dbx.MustOpen("postgres", config.DSN)
+	if err != nil {
+		log.Fatal(err)
+		log.Println("Connection to DB failed, aborting...")
+	}
+
+	// Connection pool
+	db.DB().SetMaxOpenConns(config.MaxOpenConns)
+	db.DB().SetMaxIdleConns(config.MaxIdleConns)
+	db.DB().SetConnMaxLifetime(config.ConnMaxLifetime.Duration)
 	return db
 }
 
-func initRouter(db *dbx.DB) *routing.Router {
+// Select storage by config, "memory" keeps everything in process memory
+func initStore(config DatabaseConfig) (Store, func()) {
+	if config.Storage == StorageMemory {
+		log.Println("Using in-memory storage")
+		return NewMemoryStore(), func() {}
+	}
+
+	db := initDatabase(config)
+	store := NewPostgresStore(db)
+
+	// Without auto migration schema changes are left to migrate command
+	store.AutoMigrate = config.AutoMigrate
+
+	return store, func() { db.Close() }
+}
+
+// Migrate command: migrate [up | down | to VERSION | version]
+func runMigrate(config DatabaseConfig, args []string) {
+	if config.Storage != StoragePostgres {
+		log.Fatal("Migrate: migrations need postgres storage")
+	}
+
+	db := initDatabase(config)
+	defer db.Close()
+	store := NewPostgresStore(db)
+
+	version, err := store.SchemaVersion(context.Background())
+	if err != nil {
+		log.Fatal("Migrate: ", err)
+	}
+
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	target := latestSchemaVersion()
+	switch {
+	case command == "version":
+		fmt.Printf("schema version %d, latest %d\n", version, target)
+		return
+	case command == "up":
+	case command == "down":
+		target = version - 1
+	case command == "to" && len(args) > 1:
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatal("Migrate: ", err)
+		}
+	default:
+		log.Fatal("Usage: stservice migrate [up | down | to VERSION | version]")
+	}
+
+	if err := store.Migrate(target); err != nil {
+		log.Fatal("Migrate: ", err)
+	}
+	log.Printf("Schema migrated to version %d", target)
+}
+
+// Router for Social Tournament service with default options
+func initRouter(store Store) *routing.Router {
+	return newRouter(Service{store: store})
+}
+
+func newRouter(service Service) *routing.Router {
+
+	// Social Tournament Service
+	if err := service.Initialize(); err != nil {
+		log.Fatal("Initialize: ", err)
+	}
+
+	// OpenAPI document of routes
+	spec := apiSpec()
+	if !service.ResetEnabled() {
+		delete(spec.Paths, "/reset")
+		delete(spec.Paths, "/v2/reset")
+	}
+
+	// Requests are counted by route even if service has no metrics
+	if service.Metrics == nil {
+		service.Metrics = NewMetrics()
+	}
+
+	// Ozzo-router
+	router := routing.New()
+
+	// Middlewares
+	router.Use(
+		requestHandler(service.Logger),
+		metricsHandler(service.Metrics, spec),
+		slash.Remover(http.StatusMovedPermanently),
+		content.TypeNegotiator(content.JSON),
+		errorHandler,
+	)
 
-        // Social Tournament Service
-        service := Service{db: db}
-        service.Initialize()
+	// Health endpoints and metrics for orchestrators are public and answer
+	// while server is draining, readiness reports draining itself
+	router.Get(`/healthz`, healthzController)
+	router.Get(`/readyz`, func(c *routing.Context) error { return readyzController(c, requestService(c, service)) })
+	router.Get(`/metrics`, func(c *routing.Context) error { return metricsController(c, service.Metrics) })
 
-        // Ozzo-router
-        router := routing.New()
+	// New requests are refused on shutdown
+	router.Use(drainHandler(service))
 
-        // Middlewares
-        router.Use(
-                access.Logger(log.Printf),
-                slash.Remover(http.StatusMovedPermanently),
-                content.TypeNegotiator(content.JSON),
-                fault.Recovery(log.Printf),
-        )
+	// Requests are validated against OpenAPI document
+	router.Use(validationHandler(spec))
+	router.Get(`/openapi.json`, func(c *routing.Context) error { return openAPIController(c, spec) })
 
-        // API endpoints
-        router.Get(`/announceTournament`, func(c *routing.Context) error { return announceTournamentController(c, service) })
-        router.Get(`/balance`, func(c *routing.Context) error { return playerBalanceController(c, service) })
-        router.Get(`/fund`, func(c *routing.Context) error { return fundController(c, service) })
-        router.Get(`/joinTournament`, func(c *routing.Context) error { return joinTournamentController(c, service) })
-        router.Get(`/reset`, func(c *routing.Context) error { return resetDBController(c, service) })
-        router.Post(`/resultTournament`, func(c *routing.Context) error { return resultTournamentController(c, service) })
-        router.Get(`/take`, func(c *routing.Context) error { return takeController(c, service) })
+	// Endpoints need API key with role, see authHandler
+	admin := authHandler(service, RoleAdmin)
+	client := authHandler(service, RoleClient)
+
+	// Destructive endpoints need confirmation token in production
+	confirm := confirmHandler(service)
+
+	// Mutating endpoints replay response for repeated Idempotency-Key
+	idempotent := idempotencyHandler(service)
+
+	// Legacy API endpoints, deprecated in favour of v2
+	legacy := router.Group(``)
+	legacy.Use(deprecated)
+	legacy.Get(`/announceTournament`, admin, idempotent, func(c *routing.Context) error { return announceTournamentController(c, requestService(c, service)) })
+	legacy.Get(`/balance`, client, func(c *routing.Context) error { return playerBalanceController(c, requestService(c, service)) })
+	legacy.Get(`/cancelTournament`, admin, confirm, idempotent, func(c *routing.Context) error { return cancelTournamentController(c, requestService(c, service)) })
+	legacy.Get(`/closeRegistration`, admin, idempotent, func(c *routing.Context) error {
+		return tournamentStatusController(c, requestService(c, service), StatusRegistrationClosed)
+	})
+	legacy.Get(`/fund`, admin, idempotent, func(c *routing.Context) error { return fundController(c, requestService(c, service)) })
+	legacy.Get(`/history`, client, func(c *routing.Context) error { return historyController(c, requestService(c, service)) })
+	legacy.Get(`/joinTournament`, client, idempotent, func(c *routing.Context) error { return joinTournamentController(c, requestService(c, service)) })
+	legacy.Get(`/leaveTournament`, client, idempotent, func(c *routing.Context) error { return leaveTournamentController(c, requestService(c, service)) })
+	legacy.Get(`/openRegistration`, admin, idempotent, func(c *routing.Context) error {
+		return tournamentStatusController(c, requestService(c, service), StatusRegistrationOpen)
+	})
+	legacy.Get(`/reconcile`, admin, func(c *routing.Context) error { return reconcileController(c, requestService(c, service)) })
+	if service.ResetEnabled() {
+		legacy.Get(`/reset`, admin, func(c *routing.Context) error { return resetDBController(c, requestService(c, service)) })
+	}
+	legacy.Post(`/resultTournament`, admin, idempotent, func(c *routing.Context) error { return resultTournamentController(c, requestService(c, service)) })
+	legacy.Get(`/startTournament`, admin, idempotent, func(c *routing.Context) error {
+		return tournamentStatusController(c, requestService(c, service), StatusRunning)
+	})
+	legacy.Get(`/take`, client, idempotent, func(c *routing.Context) error { return takeController(c, requestService(c, service)) })
+	legacy.Get(`/tournament`, client, func(c *routing.Context) error { return tournamentController(c, requestService(c, service)) })
+	legacy.Get(`/tournaments`, client, func(c *routing.Context) error { return tournamentsController(c, requestService(c, service)) })
+
+	// API v2 endpoints
+	initRouterV2(router.Group(`/v2`), service, admin, client, confirm, idempotent)
 
 	return router
 }
 
 func main() {
 
-	// Exit with return code 0 on kill.
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, syscall.SIGTERM)
-	go func() {
-		<-done
-		os.Exit(0)
-	}()
+	// Configuration from file, environment and flags
+	config, args := mustLoadConfig()
+
+	// JSON logs, messages of standard log package are info messages
+	logger := NewLogger(os.Stderr, config.Log.Level)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer())
 
+	// Schema migrations command
+	if len(args) > 0 && args[0] == "migrate" {
+		runMigrate(config.Database, args[1:])
+		return
+	}
 
 	// Http server
 	server := &http.Server{
-		Addr:           ":8080",
+		Addr:           config.Server.Addr,
 		Handler:        nil,
-		ReadTimeout:    100 * time.Second,
-		WriteTimeout:   100 * time.Second,
-		MaxHeaderBytes: 1 << 20,
+		ReadTimeout:    config.Server.ReadTimeout.Duration,
+		WriteTimeout:   config.Server.WriteTimeout.Duration,
+		MaxHeaderBytes: config.Server.MaxHeaderBytes,
+	}
+
+	store, closeStore := initStore(config.Database)
+
+	// Without implicit players they must be created before fund
+	service := Service{
+		store:                  store,
+		Drain:                  &Drain{},
+		Metrics:                NewMetrics(),
+		Logger:                 logger,
+		DisableImplicitPlayers: !config.Features.ImplicitPlayers,
+		DefaultSplitPolicy:     config.Service.DefaultSplitPolicy,
+	}
+
+	// Production disables reset and requires confirmation token
+	// in X-Confirm-Token header of destructive operations
+	service.Mode = config.Service.Mode
+	service.ConfirmToken = config.Service.ConfirmToken
+	log.Println("Mode:", service.Mode)
+	if service.Mode == ModeProd && service.ConfirmToken == "" {
+		log.Println("CONFIRM_TOKEN is not set, destructive operations are refused")
 	}
 
-        db := initDatabase()
-        defer db.Close()
+	// Admin API key enables API keys, it's accepted as admin key
+	// and used to issue other keys with POST /v2/apikeys.
+	// Without it API keys are required once the first key is issued.
+	if config.Service.AdminAPIKey != "" {
+		service.RequireAPIKey = true
+		service.AdminKey = config.Service.AdminAPIKey
+	} else {
+		log.Println("ADMIN_API_KEY is not set, API keys are required once the first key is issued")
+	}
+
+	// Connection pool statistics are exported with metrics
+	if stats, ok := store.(interface {
+		DBStats() sql.DBStats
+	}); ok {
+		service.Metrics.CollectDBStats(stats.DBStats)
+	}
 
 	// Router
-	router := initRouter(db)
+	router := newRouter(service)
 	http.Handle("/", router)
 
 	// Start HTTP server
-	log.Println("Server listen on 8080")
-	panic(server.ListenAndServe())
+	listener, err := net.Listen("tcp", config.Server.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server listen on", config.Server.Addr)
+
+	// SIGTERM and SIGINT drain requests, storage is closed after
+	// all handlers returned, so transactions aren't cut off
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	err = serve(server, listener, service.Drain, config.Server.ShutdownDelay.Duration, config.Server.ShutdownTimeout.Duration, signals)
+	closeStore()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Server stopped")
 
 }
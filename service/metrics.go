@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/go-ozzo/ozzo-routing"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets of request latency histogram in seconds
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics of service in Prometheus text format.
+// Nil metrics record nothing, so services created without metrics work.
+type Metrics struct {
+	requests     *counterVec
+	latency      *histogramVec
+	operations   *counterVec
+	pointsMoved  *counterVec
+	joinFailures *counterVec
+	dbStats      func() sql.DBStats
+	mu           sync.Mutex
+}
+
+// Create metrics with all series registered
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requests: newCounterVec("stservice_http_requests_total",
+			"HTTP requests by route, method and status", "route", "method", "status"),
+		latency: newHistogramVec("stservice_http_request_duration_seconds",
+			"HTTP request latency by route and method", latencyBuckets, "route", "method"),
+		operations: newCounterVec("stservice_operations_total",
+			"Successful business operations", "operation"),
+		pointsMoved: newCounterVec("stservice_points_moved_total",
+			"Points moved by successful business operations", "operation"),
+		joinFailures: newCounterVec("stservice_join_failures_total",
+			"Failed tournament joins by reason", "reason"),
+	}
+}
+
+// Business operations
+const (
+	OperationFund   = "fund"
+	OperationTake   = "take"
+	OperationJoin   = "join"
+	OperationResult = "result"
+)
+
+// Record successful operation which moved points
+func (m *Metrics) operation(operation string, points int64) {
+	if m == nil {
+		return
+	}
+	m.operations.add(1, operation)
+	m.pointsMoved.add(float64(points), operation)
+}
+
+// Record result of join, failure reason is error code
+func (m *Metrics) join(deposit int64, err error) {
+	if m == nil {
+		return
+	}
+	if err == nil {
+		m.operation(OperationJoin, deposit)
+		return
+	}
+
+	reason := "internal_error"
+	if e, ok := err.(*Error); ok {
+		reason = e.Code
+	}
+	m.joinFailures.add(1, reason)
+}
+
+// Record HTTP request
+func (m *Metrics) request(route string, method string, status int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requests.add(1, route, method, strconv.Itoa(status))
+	m.latency.observe(duration.Seconds(), route, method)
+}
+
+// Collect connection pool statistics on every scrape
+func (m *Metrics) CollectDBStats(stats func() sql.DBStats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dbStats = stats
+}
+
+// Write all metrics in Prometheus text format
+func (m *Metrics) Write(w io.Writer) {
+	m.requests.write(w)
+	m.latency.write(w)
+	m.operations.write(w)
+	m.pointsMoved.write(w)
+	m.joinFailures.write(w)
+
+	m.mu.Lock()
+	stats := m.dbStats
+	m.mu.Unlock()
+	if stats == nil {
+		return
+	}
+
+	s := stats()
+	writeSample(w, "stservice_db_max_open_connections", "gauge", "Maximum number of open connections", float64(s.MaxOpenConnections))
+	writeSample(w, "stservice_db_open_connections", "gauge", "Established connections, in use and idle", float64(s.OpenConnections))
+	writeSample(w, "stservice_db_in_use_connections", "gauge", "Connections currently in use", float64(s.InUse))
+	writeSample(w, "stservice_db_idle_connections", "gauge", "Idle connections", float64(s.Idle))
+	writeSample(w, "stservice_db_wait_count_total", "counter", "Connections waited for", float64(s.WaitCount))
+	writeSample(w, "stservice_db_wait_duration_seconds_total", "counter", "Time blocked waiting for connections", s.WaitDuration.Seconds())
+	writeSample(w, "stservice_db_max_idle_closed_total", "counter", "Connections closed due to max idle connections", float64(s.MaxIdleClosed))
+	writeSample(w, "stservice_db_max_lifetime_closed_total", "counter", "Connections closed due to max connection lifetime", float64(s.MaxLifetimeClosed))
+}
+
+// Write metric with one sample without labels
+func writeSample(w io.Writer, name string, kind string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, kind, name, formatValue(value))
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// Label pairs {name="value",...}, values are escaped
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Key of series in map, label values joined with separator
+// which can't appear in label value of this service
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// Counter with labels
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) add(value float64, labels ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[seriesKey(labels)] += value
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		labels := formatLabels(c.labels, strings.Split(key, "\xff"))
+		fmt.Fprintf(w, "%s%s %s\n", c.name, labels, formatValue(c.values[key]))
+	}
+}
+
+// Histogram with labels, counts are cumulative by bucket
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(value float64, labels ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := seriesKey(labels)
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, bound := range h.buckets {
+		if value <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values := strings.Split(key, "\xff")
+		s := h.series[key]
+		names := append(append([]string{}, h.labels...), "le")
+		for i, bound := range h.buckets {
+			labels := formatLabels(names, append(append([]string{}, values...), formatValue(bound)))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i])
+		}
+		labels := formatLabels(names, append(append([]string{}, values...), "+Inf"))
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), s.count)
+	}
+}
+
+// Response writer which keeps status of response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Middleware for count requests and measure latency. Route is operation
+// of OpenAPI document, so raw URLs don't create new series.
+func metricsHandler(metrics *Metrics, spec *OpenAPI) routing.Handler {
+	return func(c *routing.Context) error {
+		start := time.Now()
+		writer := &statusWriter{ResponseWriter: c.Response}
+		c.Response = writer
+
+		err := c.Next()
+
+		route := "unknown"
+		if op, _ := spec.find(c.Request.Method, c.Request.URL.Path); op != nil {
+			route = op.OperationID
+		}
+		status := writer.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.request(route, c.Request.Method, status, time.Since(start))
+		return err
+	}
+}
+
+// Metrics Controller, Prometheus text format
+func metricsController(c *routing.Context, metrics *Metrics) error {
+	c.Response.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Write(c.Response)
+	return nil
+}
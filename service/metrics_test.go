@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+
+	metrics := NewMetrics()
+	server := httptest.NewServer(newRouter(Service{store: NewMemoryStore(), Metrics: metrics}))
+	defer server.Close()
+
+	request := func(method string, path string, body string, status int) string {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		data, _ := ioutil.ReadAll(res.Body)
+		assert.Equal(t, status, res.StatusCode, method+" "+path)
+		return string(data)
+	}
+
+	request("GET", "/fund?playerId=P1&points=300", ``, 200)
+	request("GET", "/fund?playerId=P2&points=100", ``, 200)
+	request("POST", "/v2/players/P1/take", `{"points": 50}`, 200)
+	request("GET", "/announceTournament?tournamentId=1&deposit=200", ``, 200)
+	request("GET", "/joinTournament?tournamentId=1&playerId=P1", ``, 200)
+	request("GET", "/joinTournament?tournamentId=1&playerId=P2", ``, 400)
+	request("GET", "/joinTournament?tournamentId=2&playerId=P2", ``, 400)
+	request("POST", "/v2/tournaments/1/close", ``, 200)
+	request("POST", "/v2/tournaments/1/start", ``, 200)
+	request("POST", "/v2/tournaments/1/results", `{"winners": [{"playerId": "P1", "prize": 500}]}`, 200)
+	request("POST", "/v2/batch", `{"operations": [{"op": "fund", "playerId": "P3", "points": 10}]}`, 200)
+	request("GET", "/nowhere", ``, 404)
+
+	body := request("GET", "/metrics", ``, 200)
+	for _, line := range []string{
+		`stservice_operations_total{operation="fund"} 3`,
+		`stservice_operations_total{operation="take"} 1`,
+		`stservice_operations_total{operation="join"} 1`,
+		`stservice_operations_total{operation="result"} 1`,
+		`stservice_points_moved_total{operation="fund"} 410`,
+		`stservice_points_moved_total{operation="take"} 50`,
+		`stservice_points_moved_total{operation="join"} 200`,
+		`stservice_points_moved_total{operation="result"} 500`,
+		`stservice_join_failures_total{reason="insufficient_funds"} 1`,
+		`stservice_join_failures_total{reason="tournament_not_found"} 1`,
+		`stservice_http_requests_total{route="fund",method="GET",status="200"} 2`,
+		`stservice_http_requests_total{route="joinTournament",method="GET",status="400"} 2`,
+		`stservice_http_requests_total{route="takePlayer",method="POST",status="200"} 1`,
+		`stservice_http_requests_total{route="unknown",method="GET",status="404"} 1`,
+		`stservice_http_request_duration_seconds_bucket{route="fund",method="GET",le="+Inf"} 2`,
+		`stservice_http_request_duration_seconds_count{route="fund",method="GET"} 2`,
+		"# TYPE stservice_http_request_duration_seconds histogram",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+	assert.NotContains(t, body, "stservice_db_")
+}
+
+func TestMetricsDBStats(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.CollectDBStats(func() sql.DBStats {
+		return sql.DBStats{MaxOpenConnections: 20, OpenConnections: 3, InUse: 1, Idle: 2}
+	})
+
+	var buf bytes.Buffer
+	metrics.Write(&buf)
+	assert.Contains(t, buf.String(), "stservice_db_max_open_connections 20\n")
+	assert.Contains(t, buf.String(), "stservice_db_open_connections 3\n")
+	assert.Contains(t, buf.String(), "stservice_db_in_use_connections 1\n")
+	assert.Contains(t, buf.String(), "# TYPE stservice_db_idle_connections gauge\nstservice_db_idle_connections 2\n")
+}
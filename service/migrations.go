@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	"log"
+)
+
+// Structure for versioned change of database schema.
+// Up applies the change, Down reverts it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Schema migrations, ordered by version. Never change applied migrations,
+// add a new one instead.
+//
+// First migrations use IF NOT EXISTS, so databases created before
+// schema_migrations table existed are adopted as is.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create players",
+		Up: `
+			CREATE TABLE IF NOT EXISTS players (
+				id text primary key,
+				balance bigint
+			)`,
+		Down: `DROP TABLE players`,
+	},
+	{
+		Version: 2,
+		Name:    "create tournaments and games",
+		Up: `
+			CREATE TABLE IF NOT EXISTS tournaments (
+				id text primary key,
+				deposit bigint,
+				finished bool
+			);
+			CREATE TABLE IF NOT EXISTS games (
+				id bigserial,
+				tournament_id bigint,
+				player_id text,
+				backers text[]
+			);
+			CREATE UNIQUE INDEX IF NOT EXISTS games_tournament_id_player_id_idx
+				ON games USING btree(tournament_id, player_id)`,
+		Down: `
+			DROP TABLE games;
+			DROP TABLE tournaments`,
+	},
+	{
+		Version: 3,
+		Name:    "create ledger",
+		Up: `
+			CREATE SEQUENCE IF NOT EXISTS ledger_movement_seq;
+			CREATE TABLE IF NOT EXISTS ledger (
+				id bigserial primary key,
+				movement_id bigint not null,
+				kind text not null,
+				account text not null,
+				amount bigint not null,
+				tournament_id text,
+				game_id bigint,
+				created_at timestamptz not null default now()
+			);
+			CREATE INDEX IF NOT EXISTS ledger_account_id_idx
+				ON ledger USING btree(account, id);
+
+			-- Balances existed before ledger are recorded as opening movements
+			WITH p AS (
+				SELECT id, balance, nextval('ledger_movement_seq') AS movement_id
+				FROM players
+				WHERE
+					balance <> 0
+					AND NOT EXISTS (SELECT 1 FROM ledger WHERE account = 'player:' || players.id)
+			)
+			INSERT INTO ledger
+				(movement_id, kind, account, amount)
+			SELECT p.movement_id, 'opening', a.account, a.amount
+			FROM p
+			CROSS JOIN LATERAL (VALUES
+				('external', -p.balance),
+				('player:' || p.id, p.balance)
+			) a(account, amount)`,
+		Down: `
+			DROP TABLE ledger;
+			DROP SEQUENCE ledger_movement_seq`,
+	},
+	{
+		Version: 4,
+		Name:    "create idempotency keys",
+		Up: `
+			CREATE TABLE IF NOT EXISTS idempotency_keys (
+				key text primary key,
+				fingerprint text not null,
+				status integer not null default 0,
+				body text not null default '',
+				created_at timestamptz not null
+			)`,
+		Down: `DROP TABLE idempotency_keys`,
+	},
+	{
+		Version: 5,
+		Name:    "games tournament_id as text",
+		Up: `
+			ALTER TABLE games
+				ALTER COLUMN tournament_id TYPE text USING tournament_id::text`,
+		// Fails if any tournament id is not a number
+		Down: `
+			ALTER TABLE games
+				ALTER COLUMN tournament_id TYPE bigint USING tournament_id::bigint`,
+	},
+	{
+		Version: 6,
+		Name:    "tournaments split policy",
+		Up: `
+			ALTER TABLE tournaments
+				ADD COLUMN split_policy text not null default 'player'`,
+		Down: `
+			ALTER TABLE tournaments
+				DROP COLUMN split_policy`,
+	},
+	{
+		Version: 7,
+		Name:    "games stakes",
+		Up: `
+			ALTER TABLE games
+				ADD COLUMN stakes bigint[]`,
+		Down: `
+			ALTER TABLE games
+				DROP COLUMN stakes`,
+	},
+	{
+		Version: 8,
+		Name:    "tournaments status",
+		// Not finished tournaments accepted joins, so their registration is open
+		Up: `
+			ALTER TABLE tournaments
+				ADD COLUMN status text not null default 'announced';
+			UPDATE tournaments
+				SET status = CASE WHEN finished THEN 'finished' ELSE 'registration_open' END;
+			ALTER TABLE tournaments
+				DROP COLUMN finished`,
+		Down: `
+			ALTER TABLE tournaments
+				ADD COLUMN finished bool;
+			UPDATE tournaments
+				SET finished = status IN ('finished', 'cancelled');
+			ALTER TABLE tournaments
+				DROP COLUMN status`,
+	},
+	{
+		Version: 9,
+		Name:    "tournaments created at",
+		// Time of tournaments announced before is unknown, they get migration time
+		Up: `
+			ALTER TABLE tournaments
+				ADD COLUMN created_at timestamptz not null default now();
+			CREATE INDEX tournaments_created_at_id_idx
+				ON tournaments USING btree(created_at, id)`,
+		Down: `
+			ALTER TABLE tournaments
+				DROP COLUMN created_at`,
+	},
+	{
+		Version: 10,
+		Name:    "players status",
+		Up: `
+			ALTER TABLE players
+				ADD COLUMN status text not null default 'active'`,
+		Down: `
+			ALTER TABLE players
+				DROP COLUMN status`,
+	},
+	{
+		Version: 11,
+		Name:    "create api keys",
+		// Only sha256 of key is stored
+		Up: `
+			CREATE TABLE api_keys (
+				id text primary key,
+				hash text not null,
+				role text not null,
+				name text not null default '',
+				created_at timestamptz not null,
+				revoked_at timestamptz
+			)`,
+		Down: `DROP TABLE api_keys`,
+	},
+	{
+		Version: 12,
+		Name:    "ledger null tournament and game",
+		// Movements outside tournaments were stored with '' and 0,
+		// NULLs are read the same way, so they are kept on the way down
+		Up: `
+			UPDATE ledger SET tournament_id = NULL WHERE tournament_id = '';
+			UPDATE ledger SET game_id = NULL WHERE game_id = 0`,
+		Down: `SELECT 1`,
+	},
+}
+
+// Latest schema version known by this build
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+const migrationsTableSQL = `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version integer primary key,
+        name text not null,
+        applied_at timestamptz not null default now()
+    )
+`
+
+// Only one process at a time applies migrations
+const migrationsLockSQL = `
+    SELECT pg_advisory_xact_lock(4815162342)
+`
+
+const schemaVersionSQL = `
+    SELECT COALESCE(MAX(version), 0) FROM schema_migrations
+`
+
+// Method for get current schema version, 0 for empty database.
+// Queries are cancelled with ctx.
+func (store *PostgresStore) SchemaVersion(ctx context.Context) (int, error) {
+	var exists bool
+	err := store.db.DB().QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	err = store.db.DB().QueryRowContext(ctx, schemaVersionSQL).Scan(&version)
+	return version, err
+}
+
+// Method for migrate schema up or down to target version in one transaction
+func (store *PostgresStore) Migrate(target int) error {
+	latest := latestSchemaVersion()
+	if target < 0 || target > latest {
+		return fmt.Errorf("unknown schema version %d, latest is %d", target, latest)
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = migrate(tx, target)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func migrate(tx *dbx.Tx, target int) error {
+	for _, sql := range []string{migrationsLockSQL, migrationsTableSQL} {
+		if _, err := tx.NewQuery(sql).Execute(); err != nil {
+			return err
+		}
+	}
+
+	var current int
+	if err := tx.NewQuery(schemaVersionSQL).Row(&current); err != nil {
+		return err
+	}
+
+	// Database was migrated by newer build, we don't know how to work with it
+	if current > latestSchemaVersion() {
+		return fmt.Errorf("database schema version %d is newer than supported %d", current, latestSchemaVersion())
+	}
+
+	// Apply migrations up to target
+	for _, m := range migrations {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+
+		log.Printf("Migrate up to %d: %s", m.Version, m.Name)
+		if _, err := tx.NewQuery(m.Up).Execute(); err != nil {
+			return fmt.Errorf("migration %d: %v", m.Version, err)
+		}
+
+		_, err := tx.Insert("schema_migrations", dbx.Params{
+			"version": m.Version,
+			"name":    m.Name,
+		}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+
+	// Revert migrations down to target
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+
+		log.Printf("Migrate down from %d: %s", m.Version, m.Name)
+		if _, err := tx.NewQuery(m.Down).Execute(); err != nil {
+			return fmt.Errorf("migration %d: %v", m.Version, err)
+		}
+
+		_, err := tx.Delete("schema_migrations", dbx.HashExp{"version": m.Version}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
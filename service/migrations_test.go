@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestMigrationsOrder(t *testing.T) {
+
+	// Versions start from 1 and go one by one, every migration can be reverted
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version, "Version of migration ", m.Name)
+		assert.NotEmpty(t, m.Up, "Up of migration ", m.Version)
+		assert.NotEmpty(t, m.Down, "Down of migration ", m.Version)
+	}
+	assert.Equal(t, len(migrations), latestSchemaVersion())
+}
+
+// Schema for migrations tests, it's dropped after test
+const migrationsTestSchema = "stservice_migrations_test"
+
+// PostgreSQL store in empty schema. Tests of migrations need SQL_DB,
+// they are skipped without it.
+func newMigrationsTestStore(t *testing.T) (*PostgresStore, *dbx.DB, func()) {
+	dsn := os.Getenv("SQL_DB")
+	if dsn == "" {
+		t.Skip("SQL_DB is not set")
+	}
+
+	db, err := dbx.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// One connection, so search_path is set for every query
+	db.DB().SetMaxOpenConns(1)
+	_, err = db.NewQuery(fmt.Sprintf(`
+		DROP SCHEMA IF EXISTS %[1]s CASCADE;
+		CREATE SCHEMA %[1]s;
+		SET search_path TO %[1]s`, migrationsTestSchema)).Execute()
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+
+	return NewPostgresStore(db), db, func() {
+		db.NewQuery(fmt.Sprintf(`DROP SCHEMA %s CASCADE`, migrationsTestSchema)).Execute()
+		db.Close()
+	}
+}
+
+// Tables, columns, indexes and sequences of current schema
+const schemaSnapshotSQL = `
+    SELECT 'column ' || table_name || '.' || column_name || ' ' || data_type
+        || ' ' || is_nullable || ' ' || COALESCE(column_default, '')
+    FROM information_schema.columns
+    WHERE table_schema = current_schema()
+    UNION ALL
+    SELECT 'index ' || indexdef
+    FROM pg_indexes
+    WHERE schemaname = current_schema()
+    UNION ALL
+    SELECT 'sequence ' || sequence_name
+    FROM information_schema.sequences
+    WHERE sequence_schema = current_schema()
+    ORDER BY 1
+`
+
+func schemaSnapshot(t *testing.T, db *dbx.DB) []string {
+	var items []string
+	if err := db.NewQuery(schemaSnapshotSQL).Column(&items); err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func TestMigrationsUpDown(t *testing.T) {
+
+	store, db, done := newMigrationsTestStore(t)
+	defer done()
+
+	// Schema of every version on the way up
+	latest := latestSchemaVersion()
+	snapshots := map[int][]string{}
+	for version := 0; version <= latest; version++ {
+		assert.NoError(t, store.Migrate(version), "Migrate up to ", version)
+		snapshots[version] = schemaSnapshot(t, db)
+	}
+
+	// Going down restores the same schema
+	for version := latest - 1; version >= 0; version-- {
+		assert.NoError(t, store.Migrate(version), "Migrate down to ", version)
+		assert.Equal(t, snapshots[version], schemaSnapshot(t, db), "Schema of version ", version)
+	}
+
+	version, err := store.SchemaVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+}
+
+func TestMigrationsRefuseNewerSchema(t *testing.T) {
+
+	store, db, done := newMigrationsTestStore(t)
+	defer done()
+
+	assert.NoError(t, store.Initialize())
+
+	// Schema migrated by a newer build
+	_, err := db.Insert("schema_migrations", dbx.Params{
+		"version": latestSchemaVersion() + 1,
+		"name":    "from the future",
+	}).Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Initialize()
+	if assert.Error(t, err, "Initialize with newer schema") {
+		assert.Contains(t, err.Error(), "is newer than supported")
+	}
+	assert.Error(t, store.Migrate(latestSchemaVersion()), "Migrate newer schema")
+}
+
+// Schema created by service before migrations existed
+const baselineSchemaSQL = `
+    CREATE TABLE players (id text primary key, balance bigint);
+    CREATE TABLE tournaments (id text primary key, deposit bigint, finished bool);
+    CREATE TABLE games (id bigserial, tournament_id bigint, player_id text, backers text[]);
+    CREATE UNIQUE INDEX ON games USING btree(tournament_id, player_id);
+
+    INSERT INTO players (id, balance) VALUES ('P1', 300), ('P2', 0);
+    INSERT INTO tournaments (id, deposit, finished) VALUES ('1', 1000, false), ('2', 500, true);
+    INSERT INTO games (tournament_id, player_id, backers) VALUES (1, 'P1', '{P2}');
+`
+
+func TestMigrationsUpgradeBaseline(t *testing.T) {
+
+	store, db, done := newMigrationsTestStore(t)
+	defer done()
+
+	if _, err := db.NewQuery(baselineSchemaSQL).Execute(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Baseline schema is adopted and migrated, the second run changes nothing
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, store.Initialize(), "Initialize baseline schema")
+	}
+	version, err := store.SchemaVersion(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, latestSchemaVersion(), version)
+
+	// Games refer tournaments by text id
+	var dataType string
+	err = db.NewQuery(`
+		SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'games' AND column_name = 'tournament_id'`).Row(&dataType)
+	assert.NoError(t, err)
+	assert.Equal(t, "text", dataType, "Type of games.tournament_id")
+
+	store.Transactional(func(tx StoreTx) error {
+		game, err := tx.Game("1", "P1")
+		assert.NoError(t, err, "Game of baseline tournament")
+		assert.Equal(t, []string{"P2"}, game.Backers)
+
+		// Not finished tournaments accepted joins
+		for id, status := range map[string]string{"1": StatusRegistrationOpen, "2": StatusFinished} {
+			tournament, err := tx.Tournament(id)
+			assert.NoError(t, err)
+			assert.Equal(t, status, tournament.Status, "Status of tournament ", id)
+		}
+
+		// Existing balances are opening movements, empty balances have none
+		balances, err := tx.LedgerBalances()
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{"external": -300, "player:P1": 300}, balances)
+		return nil
+	})
+
+	reconciliation, err := (&Service{store: store}).Reconcile()
+	assert.NoError(t, err)
+	for _, r := range reconciliation {
+		assert.True(t, r.Matched, "Ledger of ", r.PlayerID)
+	}
+}
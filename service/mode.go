@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/go-ozzo/ozzo-routing"
+)
+
+// Environment modes of service. Production doesn't register /reset
+// and destructive operations need confirmation token.
+const (
+	ModeDev  = "dev"
+	ModeTest = "test"
+	ModeProd = "prod"
+)
+
+// Parse mode, empty mode is dev
+func parseMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return ModeDev, nil
+	case ModeDev, ModeTest, ModeProd:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown mode %q, must be dev, test or prod", mode)
+}
+
+// Structure for service status response
+type ServiceStatus struct {
+	Mode                 string `json:"mode"`
+	ResetEnabled         bool   `json:"resetEnabled"`
+	ConfirmationRequired bool   `json:"confirmationRequired"`
+	APIKeyRequired       bool   `json:"apiKeyRequired"`
+}
+
+// Method for check database reset is allowed, it's disabled in production
+func (service *Service) ResetEnabled() bool {
+	return service.Mode != ModeProd
+}
+
+// Method for current mode and safety settings of service
+func (service *Service) Status() ServiceStatus {
+	mode := service.Mode
+	if mode == "" {
+		mode = ModeDev
+	}
+	required, err := service.APIKeyRequired()
+	return ServiceStatus{
+		Mode:                 mode,
+		ResetEnabled:         service.ResetEnabled(),
+		ConfirmationRequired: mode == ModeProd,
+		APIKeyRequired:       required || err != nil,
+	}
+}
+
+// Middleware for destructive operations: in production request must have
+// X-Confirm-Token header equal to ConfirmToken of service.
+// Without ConfirmToken destructive operations are refused in production.
+func confirmHandler(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		if service.Mode != ModeProd {
+			return nil
+		}
+
+		token := c.Request.Header.Get("X-Confirm-Token")
+		if service.ConfirmToken == "" || token == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(service.ConfirmToken)) != 1 {
+			return ErrConfirmationRequired
+		}
+		return nil
+	}
+}
+
+// Service status Controller
+func statusController(c *routing.Context, service Service) error {
+	return c.Write(service.Status())
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProductionMode(t *testing.T) {
+
+	server := httptest.NewServer(newRouter(Service{store: NewMemoryStore(), Mode: ModeProd, ConfirmToken: "yes"}))
+	defer server.Close()
+
+	request := func(method string, path string, confirm string, status int, code string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if confirm != "" {
+			req.Header.Set("X-Confirm-Token", confirm)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var apiError APIError
+		json.NewDecoder(res.Body).Decode(&apiError)
+		assert.Equal(t, status, res.StatusCode, method+" "+path)
+		if code != "" {
+			assert.Equal(t, code, apiError.Code, method+" "+path)
+		}
+	}
+
+	// Reset isn't registered
+	request("GET", "/reset", "", 404, "not_found")
+	request("POST", "/v2/reset", "", 404, "not_found")
+
+	var status ServiceStatus
+	res, err := http.Get(server.URL + "/v2/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(res.Body).Decode(&status)
+	res.Body.Close()
+	assert.Equal(t, ServiceStatus{Mode: ModeProd, ConfirmationRequired: true}, status)
+
+	// Destructive operations need confirmation token
+	request("GET", "/fund?playerId=P1&points=100", "", 200, "")
+	request("GET", "/announceTournament?tournamentId=1&deposit=100", "", 200, "")
+	request("POST", "/v2/tournaments/1/cancel", "", 428, "confirmation_required")
+	request("GET", "/cancelTournament?tournamentId=1", "no", 428, "confirmation_required")
+	request("POST", "/v2/tournaments/1/cancel", "yes", 200, "")
+	request("POST", "/v2/players/P1/close", "", 428, "confirmation_required")
+
+	// Service refuses reset even if called directly
+	service := Service{store: NewMemoryStore(), Mode: ModeProd}
+	assert.Equal(t, ErrResetDisabled, service.ResetDB())
+}
+
+func TestDevelopmentMode(t *testing.T) {
+
+	server := httptest.NewServer(newRouter(Service{store: NewMemoryStore(), Mode: ModeTest}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/reset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+
+	var status ServiceStatus
+	res, err = http.Get(server.URL + "/v2/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(res.Body).Decode(&status)
+	res.Body.Close()
+	assert.Equal(t, ServiceStatus{Mode: ModeTest, ResetEnabled: true}, status)
+
+	_, err = parseMode("staging")
+	assert.Error(t, err)
+	mode, _ := parseMode("")
+	assert.Equal(t, ModeDev, mode)
+}
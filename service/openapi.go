@@ -0,0 +1,560 @@
+package main
+
+import (
+	"github.com/go-ozzo/ozzo-routing"
+)
+
+// Structures for OpenAPI 3 document, only the parts used by this service
+type OpenAPI struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security,omitempty"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// Operations of path by lowercase HTTP method
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// API key is sent in X-API-Key header or as bearer token
+type SecurityScheme struct {
+	Type   string `json:"type"`
+	Name   string `json:"name,omitempty"`
+	In     string `json:"in,omitempty"`
+	Scheme string `json:"scheme,omitempty"`
+}
+
+type Schema struct {
+	Ref        string             `json:"$ref,omitempty"`
+	Type       string             `json:"type,omitempty"`
+	Format     string             `json:"format,omitempty"`
+	Enum       []string           `json:"enum,omitempty"`
+	Minimum    *int64             `json:"minimum,omitempty"`
+	Maximum    *int64             `json:"maximum,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+
+	// Schema of values of map object
+	AdditionalProperties *Schema `json:"additionalProperties,omitempty"`
+}
+
+// Schema constructors
+func ref(name string) *Schema {
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func stringSchema(enum ...string) *Schema {
+	return &Schema{Type: "string", Enum: enum}
+}
+
+func dateTimeSchema() *Schema {
+	return &Schema{Type: "string", Format: "date-time"}
+}
+
+func integerSchema() *Schema {
+	return &Schema{Type: "integer", Format: "int64"}
+}
+
+func rangeSchema(min int64, max int64) *Schema {
+	return &Schema{Type: "integer", Format: "int64", Minimum: &min, Maximum: &max}
+}
+
+func minimumSchema(min int64) *Schema {
+	return &Schema{Type: "integer", Format: "int64", Minimum: &min}
+}
+
+func booleanSchema() *Schema {
+	return &Schema{Type: "boolean"}
+}
+
+func arraySchema(items *Schema) *Schema {
+	return &Schema{Type: "array", Items: items}
+}
+
+func objectSchema(required []string, properties map[string]*Schema) *Schema {
+	return &Schema{Type: "object", Required: required, Properties: properties}
+}
+
+// Parameter constructors
+func queryParam(name string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Schema: schema}
+}
+
+func requiredParam(name string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Required: true, Schema: schema}
+}
+
+func pathParam(name string) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Schema: stringSchema()}
+}
+
+// Parameters accepted by endpoints wrapped with idempotencyHandler
+var idempotencyParams = []Parameter{
+	{Name: "Idempotency-Key", In: "header", Schema: stringSchema()},
+	queryParam("requestId", stringSchema()),
+}
+
+// Confirmation of destructive operation, required in production
+var confirmParam = Parameter{Name: "X-Confirm-Token", In: "header", Schema: stringSchema()}
+
+// JSON request body with schema
+func jsonBody(schema *Schema) *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content:  map[string]MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+// Responses with JSON body for status and error for any other status
+func jsonResponses(status string, schema *Schema) map[string]Response {
+	return map[string]Response{
+		status: {
+			Description: "Success",
+			Content:     map[string]MediaType{"application/json": {Schema: schema}},
+		},
+		"default": {
+			Description: "Error",
+			Content:     map[string]MediaType{"application/json": {Schema: ref("Error")}},
+		},
+	}
+}
+
+// Operation of legacy API, legacy routes are deprecated in favour of v2
+func legacyOperation(id string, summary string, response string, params ...Parameter) *Operation {
+	return &Operation{
+		OperationID: id,
+		Summary:     summary,
+		Deprecated:  true,
+		Parameters:  params,
+		Responses:   jsonResponses("200", ref(response)),
+	}
+}
+
+// Operation of legacy API which changes state
+func legacyMutation(id string, summary string, params ...Parameter) *Operation {
+	return legacyOperation(id, summary, "Empty", append(params, idempotencyParams...)...)
+}
+
+// Operation of v2 API
+func operation(id string, summary string, status string, response string, params ...Parameter) *Operation {
+	return &Operation{
+		OperationID: id,
+		Summary:     summary,
+		Parameters:  params,
+		Responses:   jsonResponses(status, ref(response)),
+	}
+}
+
+// Operation of v2 API which changes state, body is optional
+func mutation(id string, summary string, status string, response string, body *Schema, params ...Parameter) *Operation {
+	op := operation(id, summary, status, response, append(params, idempotencyParams...)...)
+	if body != nil {
+		op.RequestBody = jsonBody(body)
+	}
+	return op
+}
+
+// Filters of tournaments list
+var tournamentsParams = []Parameter{
+	queryParam("status", arraySchema(stringSchema(tournamentStatuses...))),
+	queryParam("minDeposit", integerSchema()),
+	queryParam("maxDeposit", integerSchema()),
+	queryParam("createdFrom", dateTimeSchema()),
+	queryParam("createdTo", dateTimeSchema()),
+	queryParam("playerId", stringSchema()),
+	queryParam("cursor", stringSchema()),
+	queryParam("limit", rangeSchema(1, maxTournamentsLimit)),
+}
+
+// Filters of player history
+var historyParams = []Parameter{
+	queryParam("type", arraySchema(stringSchema(movementKinds...))),
+	queryParam("createdFrom", dateTimeSchema()),
+	queryParam("createdTo", dateTimeSchema()),
+	queryParam("cursor", stringSchema()),
+	queryParam("limit", rangeSchema(1, maxHistoryLimit)),
+}
+
+var tournamentStatuses = []string{
+	StatusAnnounced, StatusRegistrationOpen, StatusRegistrationClosed,
+	StatusRunning, StatusFinished, StatusCancelled,
+}
+
+var movementKinds = []string{
+	MovementOpening, MovementFund, MovementTake, MovementTransfer, MovementDeposit, MovementBacking,
+	MovementPrize, MovementHouse, MovementRefund, MovementLeave,
+}
+
+// OpenAPI document of all routes registered in initRouter
+func apiSpec() *OpenAPI {
+	tournamentID := requiredParam("tournamentId", stringSchema())
+	playerID := requiredParam("playerId", stringSchema())
+	points := requiredParam("points", minimumSchema(1))
+
+	paths := map[string]PathItem{
+		"/openapi.json": {
+			"get": &Operation{
+				OperationID: "openapi",
+				Summary:     "This document",
+				Responses:   jsonResponses("200", &Schema{Type: "object"}),
+			},
+		},
+
+		"/healthz": {"get": operation("healthz", "Process is alive", "200", "Health")},
+		"/readyz": {"get": &Operation{
+			OperationID: "readyz",
+			Summary:     "Database is reachable, schema is up to date and server isn't draining",
+			Responses: map[string]Response{
+				"200": {Description: "Ready", Content: map[string]MediaType{"application/json": {Schema: ref("Health")}}},
+				"503": {Description: "Not ready", Content: map[string]MediaType{"application/json": {Schema: ref("Health")}}},
+			},
+		}},
+
+		"/metrics": {"get": &Operation{
+			OperationID: "metrics",
+			Summary:     "Prometheus metrics",
+			Responses: map[string]Response{
+				"200": {Description: "Metrics in Prometheus text format", Content: map[string]MediaType{"text/plain": {Schema: stringSchema()}}},
+			},
+		}},
+
+		// Legacy API
+		"/announceTournament": {"get": legacyMutation("announceTournament", "Announce tournament",
+			tournamentID,
+			requiredParam("deposit", integerSchema()),
+			queryParam("splitPolicy", stringSchema(SplitPlayer, SplitRoundRobin, SplitHouse)),
+			queryParam("openRegistration", booleanSchema()),
+		)},
+		"/balance":           {"get": legacyOperation("balance", "Player balance", "Player", playerID)},
+		"/cancelTournament":  {"get": legacyMutation("cancelTournament", "Cancel tournament and refund deposits", tournamentID, confirmParam)},
+		"/closeRegistration": {"get": legacyMutation("closeRegistration", "Close tournament registration", tournamentID)},
+		"/fund":              {"get": legacyMutation("fund", "Fund player", playerID, points)},
+		"/history": {"get": legacyOperation("history", "Player history", "HistoryPage",
+			append([]Parameter{playerID}, historyParams...)...,
+		)},
+		"/joinTournament": {"get": legacyMutation("joinTournament", "Join tournament",
+			tournamentID,
+			playerID,
+			queryParam("backerId", arraySchema(stringSchema())),
+			queryParam("playerStake", minimumSchema(0)),
+			queryParam("stake", arraySchema(minimumSchema(0))),
+		)},
+		"/leaveTournament":  {"get": legacyMutation("leaveTournament", "Leave tournament", tournamentID, playerID)},
+		"/openRegistration": {"get": legacyMutation("openRegistration", "Open tournament registration", tournamentID)},
+		"/reconcile":        {"get": legacyOperation("reconcile", "Reconcile balances with ledger", "Reconcile")},
+		"/reset":            {"get": legacyOperation("reset", "Reset database", "Empty")},
+		"/resultTournament": {"post": func() *Operation {
+			op := legacyOperation("resultTournament", "Result tournament", "Empty", idempotencyParams...)
+			op.RequestBody = jsonBody(ref("Results"))
+			return op
+		}()},
+		"/startTournament": {"get": legacyMutation("startTournament", "Start tournament", tournamentID)},
+		"/take":            {"get": legacyMutation("take", "Take points from player", playerID, points)},
+		"/tournament":      {"get": legacyOperation("tournament", "Tournament details", "TournamentDetails", tournamentID)},
+		"/tournaments":     {"get": legacyOperation("tournaments", "List tournaments", "TournamentsPage", tournamentsParams...)},
+
+		// API v2
+		"/v2/apikeys": {
+			"get": operation("listAPIKeys", "List API keys", "200", "APIKeys"),
+			"post": func() *Operation {
+				op := operation("issueAPIKey", "Issue API key, key is returned only once", "201", "IssuedAPIKey")
+				op.RequestBody = jsonBody(ref("APIKeyRequest"))
+				return op
+			}(),
+		},
+		"/v2/apikeys/{id}": {"delete": &Operation{
+			OperationID: "revokeAPIKey",
+			Summary:     "Revoke API key",
+			Parameters:  []Parameter{pathParam("id"), confirmParam},
+			Responses: map[string]Response{
+				"204":     {Description: "Revoked"},
+				"default": jsonResponses("204", nil)["default"],
+			},
+		}},
+		"/v2/batch": {"post": mutation("batch", "Batch fund and take", "200", "BatchResponse",
+			ref("BatchRequest"),
+		)},
+		"/v2/players": {"post": mutation("createPlayer", "Create player", "201", "Player",
+			ref("PlayerRequest"),
+		)},
+		"/v2/players/{id}/close": {"post": mutation("closePlayer", "Close player account with zero balance", "200", "Player",
+			nil, pathParam("id"), confirmParam,
+		)},
+		"/v2/players/{id}/freeze": {"post": mutation("freezePlayer", "Freeze player account", "200", "Player",
+			nil, pathParam("id"),
+		)},
+		"/v2/players/{id}/unfreeze": {"post": mutation("unfreezePlayer", "Unfreeze player account", "200", "Player",
+			nil, pathParam("id"),
+		)},
+		"/v2/players/{id}": {"get": operation("getPlayer", "Player balance", "200", "Player", pathParam("id"))},
+		"/v2/players/{id}/fund": {"post": mutation("fundPlayer", "Fund player", "200", "Player",
+			ref("PointsRequest"), pathParam("id"),
+		)},
+		"/v2/players/{id}/history": {"get": operation("getPlayerHistory", "Player history", "200", "HistoryPage",
+			append([]Parameter{pathParam("id")}, historyParams...)...,
+		)},
+		"/v2/players/{id}/take": {"post": mutation("takePlayer", "Take points from player", "200", "Player",
+			ref("PointsRequest"), pathParam("id"),
+		)},
+		"/v2/reconcile": {"get": operation("getReconcile", "Reconcile balances with ledger", "200", "Reconcile")},
+		"/v2/reset":     {"post": operation("resetDatabase", "Reset database, disabled in production", "200", "Empty")},
+		"/v2/status":    {"get": operation("getStatus", "Service mode and safety settings", "200", "Status")},
+		"/v2/transfers": {"post": mutation("transfer", "Transfer points between players", "200", "Transfer",
+			ref("TransferRequest"),
+		)},
+		"/v2/tournaments": {
+			"get":  operation("listTournaments", "List tournaments", "200", "TournamentsPage", tournamentsParams...),
+			"post": mutation("announceTournamentV2", "Announce tournament", "201", "TournamentDetails", ref("TournamentRequest")),
+		},
+		"/v2/tournaments/{id}": {"get": operation("getTournament", "Tournament details", "200", "TournamentDetails", pathParam("id"))},
+		"/v2/tournaments/{id}/cancel": {"post": mutation("cancelTournamentV2", "Cancel tournament and refund deposits", "200", "TournamentDetails",
+			nil, pathParam("id"), confirmParam,
+		)},
+		"/v2/tournaments/{id}/close": {"post": mutation("closeRegistrationV2", "Close tournament registration", "200", "TournamentDetails",
+			nil, pathParam("id"),
+		)},
+		"/v2/tournaments/{id}/entries": {"post": mutation("joinTournamentV2", "Join tournament", "201", "TournamentDetails",
+			ref("EntryRequest"), pathParam("id"),
+		)},
+		"/v2/tournaments/{id}/entries/{playerId}": {"delete": mutation("leaveTournamentV2", "Leave tournament", "200", "TournamentDetails",
+			nil, pathParam("id"), pathParam("playerId"),
+		)},
+		"/v2/tournaments/{id}/open": {"post": mutation("openRegistrationV2", "Open tournament registration", "200", "TournamentDetails",
+			nil, pathParam("id"),
+		)},
+		"/v2/tournaments/{id}/results": {"post": mutation("resultTournamentV2", "Result tournament", "200", "TournamentDetails",
+			ref("ResultsRequest"), pathParam("id"),
+		)},
+		"/v2/tournaments/{id}/start": {"post": mutation("startTournamentV2", "Start tournament", "200", "TournamentDetails",
+			nil, pathParam("id"),
+		)},
+	}
+
+	schemas := map[string]*Schema{
+		"Empty": objectSchema(nil, nil),
+		"Error": objectSchema([]string{"status", "code", "message"}, map[string]*Schema{
+			"status":  integerSchema(),
+			"code":    stringSchema(),
+			"message": stringSchema(),
+		}),
+		"Player": objectSchema([]string{"playerId", "balance", "status"}, map[string]*Schema{
+			"playerId": stringSchema(),
+			"balance":  integerSchema(),
+			"status":   stringSchema(PlayerActive, PlayerFrozen, PlayerClosed),
+		}),
+		"PlayerRequest": objectSchema([]string{"playerId"}, map[string]*Schema{
+			"playerId": stringSchema(),
+		}),
+		"Winner": objectSchema([]string{"playerId", "prize"}, map[string]*Schema{
+			"playerId": stringSchema(),
+			"prize":    integerSchema(),
+		}),
+		"Results": objectSchema([]string{"tournamentId", "winners"}, map[string]*Schema{
+			"tournamentId": stringSchema(),
+			"winners":      arraySchema(ref("Winner")),
+		}),
+		"ResultsRequest": objectSchema([]string{"winners"}, map[string]*Schema{
+			"winners": arraySchema(ref("Winner")),
+		}),
+		"PointsRequest": objectSchema([]string{"points"}, map[string]*Schema{
+			"points": minimumSchema(1),
+		}),
+		"BatchOperation": objectSchema([]string{"op", "playerId", "points"}, map[string]*Schema{
+			"op":       stringSchema(BatchFund, BatchTake),
+			"playerId": stringSchema(),
+			"points":   minimumSchema(1),
+		}),
+		"BatchRequest": objectSchema([]string{"operations"}, map[string]*Schema{
+			"mode":       stringSchema(BatchAtomic, BatchBestEffort),
+			"operations": arraySchema(ref("BatchOperation")),
+		}),
+		"BatchResult": objectSchema(nil, map[string]*Schema{
+			"op":       stringSchema(BatchFund, BatchTake),
+			"playerId": stringSchema(),
+			"points":   integerSchema(),
+			"applied":  booleanSchema(),
+			"error":    ref("Error"),
+		}),
+		"BatchResponse": objectSchema(nil, map[string]*Schema{
+			"applied": integerSchema(),
+			"failed":  integerSchema(),
+			"results": arraySchema(ref("BatchResult")),
+		}),
+		"TransferRequest": objectSchema([]string{"fromPlayerId", "toPlayerId", "points"}, map[string]*Schema{
+			"fromPlayerId": stringSchema(),
+			"toPlayerId":   stringSchema(),
+			"points":       minimumSchema(1),
+		}),
+		"Transfer": objectSchema([]string{"from", "to"}, map[string]*Schema{
+			"from": ref("Player"),
+			"to":   ref("Player"),
+		}),
+		"TournamentRequest": objectSchema([]string{"tournamentId", "deposit"}, map[string]*Schema{
+			"tournamentId":     stringSchema(),
+			"deposit":          integerSchema(),
+			"splitPolicy":      stringSchema(SplitPlayer, SplitRoundRobin, SplitHouse),
+			"openRegistration": booleanSchema(),
+		}),
+		"EntryRequest": objectSchema([]string{"playerId"}, map[string]*Schema{
+			"playerId":    stringSchema(),
+			"playerStake": minimumSchema(0),
+			"backers":     arraySchema(ref("BackerRequest")),
+		}),
+		"BackerRequest": objectSchema([]string{"playerId"}, map[string]*Schema{
+			"playerId": stringSchema(),
+			"stake":    minimumSchema(0),
+		}),
+		"Tournament": objectSchema(nil, map[string]*Schema{
+			"tournamentId": stringSchema(),
+			"deposit":      integerSchema(),
+			"status":       stringSchema(tournamentStatuses...),
+			"splitPolicy":  stringSchema(SplitPlayer, SplitRoundRobin, SplitHouse),
+			"createdAt":    dateTimeSchema(),
+		}),
+		"TournamentsPage": objectSchema([]string{"tournaments"}, map[string]*Schema{
+			"tournaments": arraySchema(ref("Tournament")),
+			"nextCursor":  stringSchema(),
+		}),
+		"ParticipantShare": objectSchema(nil, map[string]*Schema{
+			"playerId":       stringSchema(),
+			"contribution":   integerSchema(),
+			"payout":         integerSchema(),
+			"heldInSuspense": booleanSchema(),
+		}),
+		"Participant": objectSchema(nil, map[string]*Schema{
+			"playerId":       stringSchema(),
+			"contribution":   integerSchema(),
+			"payout":         integerSchema(),
+			"heldInSuspense": booleanSchema(),
+			"backers":        arraySchema(ref("ParticipantShare")),
+		}),
+		"TournamentDetails": objectSchema(nil, map[string]*Schema{
+			"tournamentId": stringSchema(),
+			"deposit":      integerSchema(),
+			"status":       stringSchema(tournamentStatuses...),
+			"splitPolicy":  stringSchema(SplitPlayer, SplitRoundRobin, SplitHouse),
+			"pool":         integerSchema(),
+			"participants": arraySchema(ref("Participant")),
+		}),
+		"HistoryEntry": objectSchema(nil, map[string]*Schema{
+			"id":           integerSchema(),
+			"movementId":   integerSchema(),
+			"type":         stringSchema(movementKinds...),
+			"amount":       integerSchema(),
+			"balance":      integerSchema(),
+			"tournamentId": stringSchema(),
+			"gameId":       integerSchema(),
+			"createdAt":    dateTimeSchema(),
+		}),
+		"HistoryPage": objectSchema([]string{"playerId", "entries"}, map[string]*Schema{
+			"playerId":   stringSchema(),
+			"entries":    arraySchema(ref("HistoryEntry")),
+			"nextCursor": stringSchema(),
+		}),
+		"APIKeyRequest": objectSchema([]string{"role"}, map[string]*Schema{
+			"role": stringSchema(RoleAdmin, RoleClient),
+			"name": stringSchema(),
+		}),
+		"APIKey": objectSchema(nil, map[string]*Schema{
+			"id":        stringSchema(),
+			"role":      stringSchema(RoleAdmin, RoleClient),
+			"name":      stringSchema(),
+			"createdAt": dateTimeSchema(),
+			"revokedAt": dateTimeSchema(),
+		}),
+		"APIKeys": arraySchema(ref("APIKey")),
+		"IssuedAPIKey": objectSchema(nil, map[string]*Schema{
+			"id":        stringSchema(),
+			"key":       stringSchema(),
+			"role":      stringSchema(RoleAdmin, RoleClient),
+			"name":      stringSchema(),
+			"createdAt": dateTimeSchema(),
+		}),
+		"HealthCheck": objectSchema([]string{"status"}, map[string]*Schema{
+			"status": stringSchema(HealthOK, HealthUnavailable),
+			"error":  stringSchema(),
+		}),
+		"Health": objectSchema([]string{"status"}, map[string]*Schema{
+			"status": stringSchema(HealthOK, HealthUnavailable),
+			"checks": {Type: "object", AdditionalProperties: ref("HealthCheck")},
+		}),
+		"Status": objectSchema([]string{"mode"}, map[string]*Schema{
+			"mode":                 stringSchema(ModeDev, ModeTest, ModeProd),
+			"resetEnabled":         booleanSchema(),
+			"confirmationRequired": booleanSchema(),
+			"apiKeyRequired":       booleanSchema(),
+		}),
+		"Reconciliation": objectSchema(nil, map[string]*Schema{
+			"playerId":      stringSchema(),
+			"balance":       integerSchema(),
+			"ledgerBalance": integerSchema(),
+			"matched":       booleanSchema(),
+		}),
+		"Reconcile": objectSchema(nil, map[string]*Schema{
+			"players":    arraySchema(ref("Reconciliation")),
+			"mismatches": integerSchema(),
+		}),
+	}
+
+	return &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    Info{Title: "Social Tournament Service", Version: "2"},
+		Paths:   paths,
+		Components: Components{
+			Schemas: schemas,
+			SecuritySchemes: map[string]*SecurityScheme{
+				"apiKey":     {Type: "apiKey", Name: "X-API-Key", In: "header"},
+				"bearerAuth": {Type: "http", Scheme: "bearer"},
+			},
+		},
+		// Only when service requires API keys, /openapi.json, /v2/status
+		// and health endpoints are always public
+		Security: []map[string][]string{{"apiKey": {}}, {"bearerAuth": {}}},
+	}
+}
+
+// OpenAPI document Controller
+func openAPIController(c *routing.Context, spec *OpenAPI) error {
+	return c.Write(spec)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Every route has operation in OpenAPI document and every operation has route
+func TestOpenAPIRoutes(t *testing.T) {
+	router := initRouter(NewMemoryStore())
+	spec := apiSpec()
+
+	operations := map[string]bool{}
+	for path, item := range spec.Paths {
+		for method := range item {
+			operations[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	for _, route := range router.Routes() {
+		path := strings.NewReplacer("<", "{", ">", "}").Replace(route.Path())
+		operation := route.Method() + " " + path
+		assert.True(t, operations[operation], "Operation for route "+operation)
+		delete(operations, operation)
+	}
+	assert.Empty(t, operations, "Operations without routes")
+
+	// All references are resolved
+	var check func(schema *Schema)
+	check = func(schema *Schema) {
+		if schema == nil {
+			return
+		}
+		if schema.Ref != "" {
+			name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+			assert.NotNil(t, spec.Components.Schemas[name], "Schema "+name)
+			return
+		}
+		check(schema.Items)
+		for _, property := range schema.Properties {
+			check(property)
+		}
+	}
+	for _, item := range spec.Paths {
+		for _, op := range item {
+			for _, param := range op.Parameters {
+				check(param.Schema)
+			}
+			if op.RequestBody != nil {
+				check(op.RequestBody.Content["application/json"].Schema)
+			}
+			for _, response := range op.Responses {
+				check(response.Content["application/json"].Schema)
+			}
+		}
+	}
+	for _, schema := range spec.Components.Schemas {
+		check(schema)
+	}
+}
+
+func TestRequestValidation(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	request := func(method string, path string, body string) (int, APIError) {
+		var apiError APIError
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(&apiError)
+		return res.StatusCode, apiError
+	}
+
+	var spec OpenAPI
+	res, err := http.Get(server.URL + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(res.Body).Decode(&spec)
+	res.Body.Close()
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+	assert.NotNil(t, spec.Paths["/v2/tournaments/{id}/entries"]["post"], "Join operation")
+
+	cases := []struct {
+		method  string
+		path    string
+		body    string
+		message string
+	}{
+		{"GET", "/fund?playerId=P1&points=0", ``, "invalid points: must be at least 1"},
+		{"GET", "/take?points=1", ``, "playerId is requred"},
+		{"GET", "/tournaments?status=open", ``, "invalid status: must be one of announced, registration_open, registration_closed, running, finished, cancelled"},
+		{"GET", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&playerStake=1&stake=x", ``, "invalid stake: must be integer"},
+		{"GET", "/history?playerId=P1&createdFrom=today", ``, "invalid createdFrom: must be RFC 3339 time"},
+		{"POST", "/resultTournament", `{"tournamentId": "1"}`, "body.winners is requred"},
+		{"POST", "/resultTournament", `{"tournamentId": "1", "winners": [{"playerId": "P1", "prize": 1.5}]}`, "body.winners[0].prize must be integer"},
+		{"POST", "/v2/players/P1/fund", `{"points": "10"}`, "body.points must be integer"},
+		{"POST", "/v2/tournaments", `{"tournamentId": "1", "deposit": 100, "openRegistration": "yes"}`, "body.openRegistration must be boolean"},
+		{"POST", "/v2/tournaments/1/entries", `{"playerId": "P1", "backers": [{"stake": 10}]}`, "body.backers[0].playerId is requred"},
+		{"POST", "/v2/tournaments/1/results", `[]`, "body must be object"},
+		{"POST", "/v2/tournaments/1/results", `{`, "invalid JSON body"},
+	}
+
+	for _, c := range cases {
+		status, apiError := request(c.method, c.path, c.body)
+		assert.Equal(t, 400, status, "Status for "+c.path)
+		assert.Equal(t, "bad_request", apiError.Code, "Code for "+c.path)
+		assert.Equal(t, c.message, apiError.Message, "Message for "+c.path)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+)
+
+// Player statuses
+const (
+	PlayerActive = "active"
+	PlayerFrozen = "frozen" // can't fund, take, join or back until unfrozen, still gets refunds and prizes
+	PlayerClosed = "closed" // closed for good
+)
+
+// Allowed transitions between player statuses
+var playerTransitions = map[string][]string{
+	PlayerActive: {PlayerFrozen, PlayerClosed},
+	PlayerFrozen: {PlayerActive, PlayerClosed},
+}
+
+// Load player and check that account is active
+func activePlayer(tx StoreTx, id string) (Players, error) {
+	player, err := tx.Player(id)
+	if err == sql.ErrNoRows {
+		return player, ErrPlayerNotFound
+	}
+	if err != nil {
+		return player, err
+	}
+
+	switch player.Status {
+	case PlayerFrozen:
+		return player, ErrPlayerFrozen
+	case PlayerClosed:
+		return player, ErrPlayerClosed
+	}
+	return player, nil
+}
+
+// Credit refund or prize to player, returns ledger account which gets points.
+// Frozen player is credited, points just can't be moved until unfrozen.
+// Closed account stays empty, its points go to suspense account.
+func payoutPoints(tx StoreTx, id string, points int64) (string, error) {
+	player, err := tx.Player(id)
+	if err == sql.ErrNoRows {
+		return "", ErrPlayerNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if player.Status == PlayerClosed {
+		return suspenseAccount, nil
+	}
+	return playerAccount(id), creditPoints(tx, id, points)
+}
+
+// Method for create active player with zero balance
+func (service *Service) CreatePlayer(id string) (Players, error) {
+	player := Players{ID: id, Status: PlayerActive}
+
+	err := service.store.Transactional(func(tx StoreTx) error {
+		_, err := tx.Player(id)
+		if err == nil {
+			return ErrPlayerExists
+		}
+		if err != sql.ErrNoRows {
+			service.Logger.Error("database error", "error", err, "playerId", id)
+			return err
+		}
+
+		err = tx.InsertPlayer(player)
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "playerId", id)
+		}
+		return err
+	})
+
+	return player, err
+}
+
+// Method for freeze, unfreeze or close player account.
+// Account can be closed only with zero balance.
+func (service *Service) ChangePlayerStatus(id string, status string) (Players, error) {
+	var player Players
+
+	err := service.store.Transactional(func(tx StoreTx) error {
+		// Player is locked, so balance can't change before it's closed
+		var err error
+		player, err = tx.LockPlayer(id, LockForUpdate)
+		if err == sql.ErrNoRows {
+			return ErrPlayerNotFound
+		}
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "playerId", id, "status", status)
+			return err
+		}
+
+		allowed := false
+		for _, s := range playerTransitions[player.Status] {
+			allowed = allowed || s == status
+		}
+		if !allowed {
+			if player.Status == PlayerClosed {
+				return ErrPlayerClosed
+			}
+			return ErrInvalidPlayerTransition
+		}
+
+		if status == PlayerClosed && player.Balance != 0 {
+			return ErrPlayerHasBalance
+		}
+
+		if err := tx.UpdatePlayerStatus(id, status); err != nil {
+			service.Logger.Error("database error", "error", err, "playerId", id, "status", status)
+			return err
+		}
+		player.Status = status
+		return nil
+	})
+
+	return player, err
+}
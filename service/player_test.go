@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlayerLifecycle(t *testing.T) {
+
+	server := httptest.NewServer(newRouter(Service{store: NewMemoryStore(), DisableImplicitPlayers: true}))
+	defer server.Close()
+
+	request := func(method string, path string, body string, status int, code string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		var apiError APIError
+		json.NewDecoder(res.Body).Decode(&apiError)
+		assert.Equal(t, status, res.StatusCode, method+" "+path+" "+body)
+		if code != "" {
+			assert.Equal(t, code, apiError.Code, method+" "+path+" "+body)
+		}
+	}
+
+	// Fund doesn't create players
+	request("GET", "/fund?playerId=P1&points=100", ``, 400, "player_not_found")
+
+	request("POST", "/v2/players", `{"playerId": "P1"}`, 201, "")
+	request("POST", "/v2/players", `{"playerId": "P2"}`, 201, "")
+	request("POST", "/v2/players", `{"playerId": "P1"}`, 409, "player_exists")
+	request("GET", "/fund?playerId=P1&points=100", ``, 200, "")
+	request("GET", "/fund?playerId=P2&points=100", ``, 200, "")
+	request("GET", "/announceTournament?tournamentId=1&deposit=100", ``, 200, "")
+
+	// Frozen player can't move points
+	request("POST", "/v2/players/P1/freeze", ``, 200, "")
+	request("GET", "/fund?playerId=P1&points=100", ``, 400, "player_frozen")
+	request("GET", "/take?playerId=P1&points=10", ``, 400, "player_frozen")
+	request("GET", "/joinTournament?tournamentId=1&playerId=P1", ``, 400, "player_frozen")
+	request("GET", "/joinTournament?tournamentId=1&playerId=P2&backerId=P1", ``, 400, "player_frozen")
+	request("POST", "/v2/transfers", `{"fromPlayerId": "P2", "toPlayerId": "P1", "points": 10}`, 400, "player_frozen")
+	request("POST", "/v2/players/P1/freeze", ``, 409, "invalid_player_transition")
+
+	request("POST", "/v2/players/P1/unfreeze", ``, 200, "")
+	request("GET", "/joinTournament?tournamentId=1&playerId=P2&backerId=P1", ``, 200, "")
+
+	// Only empty account can be closed, closed account is closed for good
+	request("POST", "/v2/players/P1/close", ``, 409, "player_has_balance")
+	request("GET", "/take?playerId=P1&points=50", ``, 200, "")
+	request("POST", "/v2/players/P1/close", ``, 200, "")
+	request("GET", "/fund?playerId=P1&points=100", ``, 400, "player_closed")
+	request("POST", "/v2/players/P1/unfreeze", ``, 400, "player_closed")
+	request("POST", "/v2/players/P3/freeze", ``, 400, "player_not_found")
+}
+
+func TestPlayerPayouts(t *testing.T) {
+
+	store := NewMemoryStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+	service := Service{store: store}
+
+	request := func(method string, path string, body string, status int) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, status, res.StatusCode, method+" "+path+" "+body)
+	}
+
+	balance := func(id string) int64 {
+		player, _ := service.PlayerBalance(id)
+		return player.Balance
+	}
+
+	for _, p := range []string{"P1", "P2", "P3"} {
+		request("GET", "/fund?playerId="+p+"&points=100", ``, 200)
+	}
+	request("GET", "/announceTournament?tournamentId=1&deposit=100", ``, 200)
+	request("GET", "/announceTournament?tournamentId=2&deposit=100", ``, 200)
+	request("GET", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2", ``, 200)
+	request("GET", "/joinTournament?tournamentId=2&playerId=P3", ``, 200)
+
+	// Backer closes account, player and winner are frozen
+	request("GET", "/take?playerId=P2&points=50", ``, 200)
+	request("POST", "/v2/players/P2/close", ``, 200)
+	request("POST", "/v2/players/P1/freeze", ``, 200)
+	request("POST", "/v2/players/P3/freeze", ``, 200)
+
+	// Frozen players get refund and prize, closed backer's refund goes to suspense
+	request("GET", "/cancelTournament?tournamentId=1", ``, 200)
+	request("POST", "/resultTournament", `{"tournamentId": "2", "winners": [{"playerId": "P3", "prize": 300}]}`, 200)
+
+	assert.Equal(t, int64(100), balance("P1"), "Refund of frozen player")
+	assert.Equal(t, int64(0), balance("P2"), "Closed account stays empty")
+	assert.Equal(t, int64(300), balance("P3"), "Prize of frozen player")
+
+	store.Transactional(func(tx StoreTx) error {
+		balances, err := tx.LedgerBalances()
+		assert.Nil(t, err)
+		assert.Equal(t, int64(50), balances[suspenseAccount], "Suspense balance")
+		return nil
+	})
+
+	reconciliation, err := service.Reconcile()
+	assert.Nil(t, err)
+	for _, r := range reconciliation {
+		assert.True(t, r.Matched, "Ledger of ", r.PlayerID)
+	}
+}
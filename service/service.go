@@ -1,373 +1,476 @@
 package main
 
 import (
-	"errors"
-	"github.com/go-ozzo/ozzo-dbx"
-	"github.com/lib/pq"
-	"log"
-	"strings"
+	"database/sql"
+	"time"
 )
 
 // Service for impement Social Tournament login
 type Service struct {
-	db *dbx.DB
+	store Store
+
+	// Fund doesn't create players, they must be created with CreatePlayer
+	DisableImplicitPlayers bool
+
+	// Requests must have API key, AdminKey is accepted as admin key
+	// in addition to keys issued with IssueAPIKey
+	RequireAPIKey bool
+	AdminKey      string
+
+	// Environment mode, dev if empty. In prod mode reset is disabled and
+	// destructive operations need ConfirmToken, see confirmHandler.
+	Mode         string
+	ConfirmToken string
+
+	// Split policy of tournaments announced without policy,
+	// defaultSplitPolicy if empty
+	DefaultSplitPolicy string
+
+	// Requests are refused while server is draining on shutdown
+	Drain *Drain
+
+	// Business operations are counted, nil records nothing
+	Metrics *Metrics
+
+	// Structured logger, tagged with request ID for requests,
+	// see requestService. Nil logs to stderr.
+	Logger *Logger
 }
 
 // Method for create tables and indexes in database
 func (service *Service) Initialize() error {
-	log.Println("Initializing service")
-	service.CreatePlayersTable()
-	service.CreateTournamentsTables()
-	return nil
+	service.Logger.Info("initializing service")
+	return service.store.Initialize()
 }
 
-const truncateSQL = `
-	TRUNCATE games;
-	TRUNCATE tournaments;
-	TRUNCATE players;
-`
-
 // Method for reset DB for initial state
 func (service *Service) ResetDB() error {
+	if !service.ResetEnabled() {
+		return ErrResetDisabled
+	}
 
-	log.Println("Reset DB")
-
-	q := service.db.NewQuery(truncateSQL)
-	_, err := q.Execute()
+	service.Logger.Warn("reset database")
 
-	return err
+	return service.store.Reset()
 }
 
-// Method for create players table id database
-func (service *Service) CreatePlayersTable() error {
-	log.Println("Create players table")
+// Log database error with fields, domain errors are logged with request,
+// not as database errors
+func (service *Service) logError(err error, fields ...interface{}) {
+	if _, ok := err.(*Error); ok {
+		return
+	}
+	service.Logger.Error("database error", append([]interface{}{"error", err}, fields...)...)
+}
 
-	q := service.db.CreateTable("players", map[string]string{
-		"id":      "text primary key",
-		"balance": "bigint",
+// Method for fund player with points
+// Add playerId into database, if player doesn't exist
+func (service *Service) Fund(player string, points int64) error {
+	err := service.store.Transactional(func(tx StoreTx) error {
+		return service.fundPlayer(tx, player, points)
 	})
-
-	_, err := q.Execute()
 	if err != nil {
-		log.Println("DB:", err)
+		service.logError(err, "playerId", player, "points", points)
 		return err
 	}
 
-	return err
+	service.Metrics.operation(OperationFund, points)
+	return nil
 }
 
-const gamesIndexesSQL = `
-    CREATE UNIQUE INDEX ON games USING btree(tournament_id, player_id)
-`
-
-// Method for create tournaments table
-// and create games table with unique(tournamentId,playerId) index
-func (service *Service) CreateTournamentsTables() error {
-	log.Println("Create tournaments tables")
-
-	// Create tournaments table
-	q := service.db.CreateTable("tournaments", map[string]string{
-		"id":       "text primary key",
-		"deposit":  "bigint",
-		"finished": "bool",
+// Method for take points from player
+func (service *Service) Take(player string, points int64) error {
+	err := service.store.Transactional(func(tx StoreTx) error {
+		return takePlayer(tx, player, points)
 	})
-
-	_, err := q.Execute()
 	if err != nil {
-		log.Println("DB:", err)
-		//return err
+		service.logError(err, "playerId", player, "points", points)
+		return err
 	}
 
-	// Create games table
-	q = service.db.CreateTable("games", map[string]string{
-		"id":            "bigserial",
-		"tournament_id": "bigint",
-		"player_id":     "text",
-		"backers":       "text[]",
-	})
+	service.Metrics.operation(OperationTake, points)
+	return nil
+}
 
-	_, err = q.Execute()
+// Fund active player in transaction and record it in ledger,
+// player is created unless implicit players are disabled
+func (service *Service) fundPlayer(tx StoreTx, player string, points int64) error {
+	_, err := activePlayer(tx, player)
+	if err == ErrPlayerNotFound && !service.DisableImplicitPlayers {
+		err = nil
+	}
 	if err != nil {
-		log.Println("DB:", err)
-		//return err
-	} else {
+		return err
+	}
 
-		// If games table was created, create index
-		q = service.db.NewQuery(gamesIndexesSQL)
-		_, err := q.Execute()
-		if err != nil {
-			log.Println("DB:", err)
-		}
+	err = tx.FundPlayer(player, points)
+	if err != nil {
+		return err
 	}
 
-	return err
+	return recordMovement(tx, newMovement(MovementFund, externalAccount, playerAccount(player), points))
 }
 
-const fundSQL = `
-	INSERT INTO players
-    		(id, balance)
-	VALUES
-    		({:id}, {:points})
-	ON
- 		CONFLICT (id)
-	DO UPDATE SET
-    		balance = players.balance + {:points}
-                
-`
-
-// Method for fund player with points
-// Add playerId into database, if player doesn't exist
-func (service *Service) Fund(player string, points int64) error {
-	q := service.db.NewQuery(fundSQL)
-	q.Bind(dbx.Params{
-		"id":     player,
-		"points": points,
-	})
+// Take points from active player in transaction and record it in ledger
+func takePlayer(tx StoreTx, player string, points int64) error {
+	if _, err := activePlayer(tx, player); err != nil {
+		return err
+	}
 
-	_, err := q.Execute()
+	err := takePoints(tx, player, points)
 	if err != nil {
-		log.Println("DB:", err)
+		return err
 	}
 
-	return err
+	return recordMovement(tx, newMovement(MovementTake, playerAccount(player), externalAccount, points))
 }
 
-const takeSQL = `
-        UPDATE players
-	SET balance = balance - {:points}
-	WHERE 
-		id = {:id}
-		AND balance >= {:points}
-`
+// Method for move points from one player to another in one transaction.
+// Both players must exist, sender must have enough points.
+func (service *Service) Transfer(from string, to string, points int64) error {
+	if from == to {
+		return ErrSameTransferPlayer
+	}
 
-// Method for take points from player
-func (service *Service) Take(player string, points int64) (int64, error) {
-	q := service.db.NewQuery(takeSQL)
-	q.Bind(dbx.Params{
-		"id":     player,
-		"points": points,
+	err := service.store.Transactional(func(tx StoreTx) error {
+		// Frozen and closed players can't send or receive points
+		for _, player := range []string{from, to} {
+			if _, err := activePlayer(tx, player); err != nil {
+				return err
+			}
+		}
+
+		// Players are updated in the same order by every transfer,
+		// so concurrent transfers in opposite directions don't deadlock
+		if from < to {
+			if err := takePoints(tx, from, points); err != nil {
+				return err
+			}
+		}
+		if err := creditPoints(tx, to, points); err != nil {
+			return err
+		}
+		if from > to {
+			if err := takePoints(tx, from, points); err != nil {
+				return err
+			}
+		}
+
+		// Debit and credit are linked by movement id
+		return recordMovement(tx, newMovement(MovementTransfer, playerAccount(from), playerAccount(to), points))
 	})
+	if err != nil {
+		service.logError(err, "fromPlayerId", from, "toPlayerId", to, "points", points)
+	}
+	return err
+}
 
-	result, err := q.Execute()
+// Take points from player in transaction if balance is enough
+func takePoints(tx StoreTx, player string, points int64) error {
+	r, err := tx.TakePlayer(player, points)
 	if err != nil {
-		log.Println("DB:", err)
-		return 0, err
+		return err
+	}
+	if r == 1 {
+		return nil
 	}
 
-	r, err := result.RowsAffected()
+	// Nothing updated, find out why
+	_, err = tx.Player(player)
+	if err == sql.ErrNoRows {
+		return ErrPlayerNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrInsufficientFunds
+}
 
-	return r, err
+// Add points to existing player in transaction
+func creditPoints(tx StoreTx, player string, points int64) error {
+	r, err := tx.CreditPlayer(player, points)
+	if err != nil {
+		return err
+	}
+	if r == 0 {
+		return ErrPlayerNotFound
+	}
+	return nil
 }
 
 // Structure (Model) for insert new Tournaments into database
 type Tournaments struct {
-	ID       string `db:"id"`
-	Deposit  int64  `db:"deposit"`
-	Finished bool   `db:"finished"`
+	ID          string    `db:"id" json:"tournamentId"`
+	Deposit     int64     `db:"deposit" json:"deposit"`
+	Status      string    `db:"status" json:"status"`
+	SplitPolicy string    `db:"split_policy" json:"splitPolicy"`
+	CreatedAt   time.Time `db:"created_at" json:"createdAt"`
 }
 
-// Method for insert tournaments into database
-func (service *Service) AnnounceTournament(id string, deposit int64) error {
+// Method for insert tournaments into database.
+// Split policy decides who gets remainder of deposit and prizes split,
+// default policy is used if policy is empty. Tournament is announced
+// with open registration, or just announced if openRegistration is false.
+func (service *Service) AnnounceTournament(id string, deposit int64, splitPolicy string, openRegistration bool) error {
+	if splitPolicy == "" {
+		splitPolicy = service.DefaultSplitPolicy
+	}
+	if splitPolicy == "" {
+		splitPolicy = defaultSplitPolicy
+	}
+	if !validSplitPolicy(splitPolicy) {
+		return ErrInvalidSplitPolicy
+	}
+
 	// Prepare model
 	tournament := Tournaments{
-		ID:       id,
-		Deposit:  deposit,
-		Finished: false,
+		ID:          id,
+		Deposit:     deposit,
+		Status:      StatusAnnounced,
+		SplitPolicy: splitPolicy,
+		// PostgreSQL keeps microseconds, tournaments cursor must match stored time
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
 	}
-	// Insert into database
-	err := service.db.Model(&tournament).Insert()
-	if err != nil {
-		log.Println("DB:", err)
+	if openRegistration {
+		tournament.Status = StatusRegistrationOpen
 	}
+	// Insert into database, tournament id must be unique
+	return service.store.Transactional(func(tx StoreTx) error {
+		_, err := tx.Tournament(id)
+		if err == nil {
+			return ErrTournamentExists
+		}
+		if err != sql.ErrNoRows {
+			service.Logger.Error("database error", "error", err, "tournamentId", id)
+			return err
+		}
 
-	return err
+		err = tx.InsertTournament(tournament)
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id)
+		}
+		return err
+	})
 }
 
-// Method for implement Join tournament logic
-func (service *Service) JoinTournament(id string, player string, backers []string) error {
-	var tournament Tournaments
-
-	// Load from database  tournament by id (and it's not finished)
-	service.db.Select("id", "deposit", "finished").
-		From("tournaments").
-		Where(dbx.HashExp{"id": id, "finished": false}).
-		One(&tournament)
-
-		// Check if wanted tournament exit
-	if tournament == (Tournaments{}) {
-		return errors.New("not found")
+// Method for implement Join tournament logic.
+// Stakes are points paid by player (stakes[0]) and every backer
+// (stakes[i+1] for backers[i]), they must sum to the deposit.
+// If stakes are nil deposit is split equally.
+func (service *Service) JoinTournament(id string, player string, backers []string, stakes []int64) (err error) {
+	// Successful joins move deposit, failed joins are counted by reason
+	var deposit int64
+	defer func() { service.Metrics.join(deposit, err) }()
+
+	if stakes != nil && len(stakes) != 1+len(backers) {
+		return ErrInvalidStakes
+	}
+	for _, stake := range stakes {
+		if stake < 0 {
+			return ErrInvalidStakes
+		}
 	}
 
-	// Calculate points per player/backer
-	var points int64
-	backersLen := len(backers)
-	points = tournament.Deposit / (1 + int64(backersLen))
-
-	// Start transaction
-	tx, _ := service.db.Begin()
+	// Run in transaction, any error does rollback
+	return service.store.Transactional(func(tx StoreTx) error {
+		// Load from database tournament by id (and it's registration is open)
+		tournament, err := loadOpenTournament(tx, id, LockForShare)
+		if err != nil {
+			return err
+		}
+		deposit = tournament.Deposit
 
-	// Save player with backers to database
-	_, err := tx.Insert("games", dbx.Params{
-		"tournament_id": id,
-		"player_id":     player,
-		"backers":       pq.Array(backers),
-	}).Execute()
+		// Player can join tournament only once
+		_, err = tx.Game(id, player)
+		if err == nil {
+			return ErrAlreadyJoined
+		}
+		if err != sql.ErrNoRows {
+			service.Logger.Error("database error", "error", err, "tournamentId", id, "playerId", player)
+			return err
+		}
 
-	// if error do transaction rollback
-	if err != nil {
-		log.Println(err)
-		tx.Rollback()
-		return err
-	}
+		// Calculate points per player/backer, player is the first participant
+		participants := append([]string{player}, backers...)
+		shares, house := splitPoints(tournament.SplitPolicy, tournament.Deposit, len(participants))
 
-	// Take points from player balance and backers balances
-	backers = append(backers, player)
+		// Negotiated stakes must cover exactly the deposit
+		if stakes != nil {
+			var total int64
+			for _, stake := range stakes {
+				total += stake
+			}
+			if total != tournament.Deposit {
+				return ErrInvalidStakes
+			}
+			shares, house = stakes, 0
+		}
 
-	for _, p := range backers {
-		q := tx.NewQuery(takeSQL)
-		q.Bind(dbx.Params{
-			"id":     p,
-			"points": points,
+		// Save player with backers to database
+		gameID, err := tx.InsertGame(Games{
+			TournamentID: id,
+			PlayerID:     player,
+			Backers:      backers,
+			Stakes:       shares,
 		})
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id, "playerId", player)
+			return err
+		}
 
-		result, err := q.Execute()
+		// Take points from player balance and backers balances
+		for i, p := range participants {
+			// Player or backer doesn't exist, isn't active or can't pay, do rollback
+			if _, err := activePlayer(tx, p); err != nil {
+				return err
+			}
+			if err := takePoints(tx, p, shares[i]); err != nil {
+				return err
+			}
 
-		// if error do transaction rollback
+			// Record deposit in ledger, player pays deposit, backers pay backing
+			kind := MovementBacking
+			if i == 0 {
+				kind = MovementDeposit
+			}
+			movement := newMovement(kind, playerAccount(p), tournamentAccount(id), shares[i])
+			movement.TournamentID = id
+			movement.GameID = gameID
+			if err := recordMovement(tx, movement); err != nil {
+				return err
+			}
+		}
+
+		// House covers remainder of deposit
+		movement := newMovement(MovementHouse, houseAccount, tournamentAccount(id), house)
+		movement.TournamentID = id
+		movement.GameID = gameID
+		return recordMovement(tx, movement)
+	})
+}
+
+// Method for leave tournament before it starts.
+// Game is deleted, player and backers get back points paid on join.
+func (service *Service) LeaveTournament(id string, player string) error {
+	// Run in transaction, any error does rollback
+	return service.store.Transactional(func(tx StoreTx) error {
+		// Load tournament, player can leave only while registration is open
+		tournament, err := loadOpenTournament(tx, id, LockForUpdate)
 		if err != nil {
-			log.Println("DB:", err)
-			tx.Rollback()
 			return err
 		}
 
-		// If no row afected, it's mean no backerId or playerId found id database, do rollback
-		r, err := result.RowsAffected()
-		if r == 0 {
-			tx.Rollback()
-			return errors.New("not found")
+		// Player must join tournament
+		game, err := tx.Game(id, player)
+		if err == sql.ErrNoRows {
+			return ErrNotJoined
+		}
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id, "playerId", player)
+			return err
 		}
-	}
-
-	// Commit success transaction
-	tx.Commit()
 
-	return nil
-}
+		if err := tx.DeleteGame(id, player); err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id, "playerId", player)
+			return err
+		}
 
-const resultSQL = `
-    UPDATE tournaments
-    SET finished = 't'
-    WHERE id = {:id}
-`
-const prizeSQL = `
-    UPDATE players
-    SET balance = balance + {:points}
-    WHERE id = {:id}
-`
-
-const winnerSQL = `
-    SELECT 
-        id,
-        player_id,
-        backers
-    FROM games
-    WHERE
-         tournament_id = {:tournamentId}        
-         AND player_id = {:playerId}
-    LIMIT 1
-`
-
-// Structure (Model) for load winner from database
-type PlayerWinner struct {
-	ID       string `db:"id"`
-	PlayerID string `db:"player_id"`
-	Backers  string `db:"backers"`
+		return refundGame(tx, tournament, game, MovementLeave)
+	})
 }
 
-// Method for imprement Result Tournament logic
+// Method for imprement Result Tournament logic, tournament must be running
 func (service *Service) ResultTournament(id string, results []Winner) error {
-	// Start transaction, do rollback if any errors
-	tx, _ := service.db.Begin()
+	return service.resultTournament(id, results, false)
+}
 
-	// Finish tournament
-	q := tx.NewQuery(resultSQL)
-	q.Bind(dbx.Params{
-		"id": id,
-	})
+// Method for results of legacy API. Tournament with open or closed
+// registration is finished at once too, clients announced and finished
+// tournaments this way before statuses existed.
+func (service *Service) ResultLegacyTournament(id string, results []Winner) error {
+	return service.resultTournament(id, results, true)
+}
 
-	result, err := q.Execute()
+func (service *Service) resultTournament(id string, results []Winner, legacy bool) error {
+	// Run in transaction, any error does rollback
+	err := service.store.Transactional(func(tx StoreTx) error {
+		// Tournament must be in database and can be finished
+		tournament, err := loadTournamentFor(tx, id, StatusFinished)
+		if err == ErrInvalidTransition && legacy &&
+			(tournament.Status == StatusRegistrationOpen || tournament.Status == StatusRegistrationClosed) {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
 
-	// Check for load error
-	if err != nil {
-		log.Println("DB:", err)
-		tx.Rollback()
-		return err
-	}
+		// Finish tournament
+		if err := tx.UpdateTournamentStatus(id, StatusFinished); err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id)
+			return err
+		}
 
-	// Tournament must be in database and updated
-	r, err := result.RowsAffected()
-	if r == 0 {
-		tx.Rollback()
-		return errors.New("not found")
-	}
+		// Process winners
+		for _, winner := range results {
 
-	// Process winners
-	for _, winner := range results {
-
-		// Load winner from database
-		q = tx.NewQuery(winnerSQL)
-		q.Bind(dbx.Params{
-			"tournamentId": id,
-			"playerId":     winner.PlayerId,
-		})
-		var playerWinner PlayerWinner
-		err = q.One(&playerWinner)
-		// winner must be
-		if err != nil {
-			tx.Rollback()
-			e := err.Error()
-			if e == "sql: no rows in result set" {
-				return errors.New("not found")
+			// Load winner from database, winner must join tournament
+			game, err := tx.Game(id, winner.PlayerId)
+			if err == sql.ErrNoRows {
+				return ErrNotJoined
 			}
-			log.Println("DB:", err)
-			return err
-		}
-		// Get backers for winner
-		pr := strings.Trim(playerWinner.Backers, "{}")
-		players := strings.Split(pr, ",")
-		players = append(players, winner.PlayerId)
-
-		// Calculate prize points for player/backers
-		playersLen := len(players)
-		points := winner.Prize / int64(playersLen)
-
-		// Update player and backers balances
-		for _, p := range players {
-			q := tx.NewQuery(prizeSQL)
-			q.Bind(dbx.Params{
-				"id":     p,
-				"points": points,
-			})
-
-			result, err := q.Execute()
-
-			// If error do rollback transaction
 			if err != nil {
-				log.Println("DB:", err)
-				tx.Rollback()
+				service.Logger.Error("database error", "error", err, "tournamentId", id, "playerId", winner.PlayerId)
 				return err
 			}
 
-			// If balance not updated do rollback transaction
-			r, err := result.RowsAffected()
-			if r == 0 {
-				tx.Rollback()
-				return errors.New("not found")
+			// Get backers for winner, player is the first participant
+			participants := append([]string{winner.PlayerId}, game.Backers...)
+
+			// Calculate prize points for player/backers proportionally to their stakes,
+			// games joined before stakes were recorded are split equally
+			shares, house := splitPoints(tournament.SplitPolicy, winner.Prize, len(participants))
+			if len(game.Stakes) == len(participants) {
+				shares, house = splitByStakes(tournament.SplitPolicy, winner.Prize, game.Stakes)
+			}
+
+			// Update player and backers balances
+			for i, p := range participants {
+				// If balance not updated do rollback transaction
+				account, err := payoutPoints(tx, p, shares[i])
+				if err != nil {
+					return err
+				}
+
+				// Record prize in ledger, prize is paid from tournament account
+				movement := newMovement(MovementPrize, tournamentAccount(id), account, shares[i])
+				movement.TournamentID = id
+				movement.GameID = game.ID
+				if err := recordMovement(tx, movement); err != nil {
+					return err
+				}
+			}
+
+			// House gets remainder of prize
+			movement := newMovement(MovementHouse, tournamentAccount(id), houseAccount, house)
+			movement.TournamentID = id
+			movement.GameID = game.ID
+			if err := recordMovement(tx, movement); err != nil {
+				return err
 			}
 		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	// Commit success transaction
-	tx.Commit()
+	// Prizes are paid from tournament account
+	var prizes int64
+	for _, winner := range results {
+		prizes += winner.Prize
+	}
+	service.Metrics.operation(OperationResult, prizes)
 	return nil
 }
 
@@ -375,11 +478,19 @@ func (service *Service) ResultTournament(id string, results []Winner) error {
 type Players struct {
 	ID      string `db:"id" json:"playerId"`
 	Balance int64  `db:"balance" json:"balance"`
+	Status  string `db:"status" json:"status"`
 }
 
 // Method for get player balance from database
 func (service *Service) PlayerBalance(id string) (Players, error) {
 	var player Players
-	err := service.db.Select().Model(id, &player)
+	err := service.store.Transactional(func(tx StoreTx) error {
+		var err error
+		player, err = tx.Player(id)
+		if err == sql.ErrNoRows {
+			return ErrPlayerNotFound
+		}
+		return err
+	})
 	return player, err
 }
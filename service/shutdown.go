@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"github.com/go-ozzo/ozzo-routing"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Drain state of server. After Start new requests are refused
+// while in-flight requests are finishing.
+type Drain struct {
+	draining int32
+}
+
+// Start draining, it can't be stopped
+func (d *Drain) Start() {
+	atomic.StoreInt32(&d.draining, 1)
+}
+
+// Check if server is draining, nil drain never drains
+func (d *Drain) Draining() bool {
+	return d != nil && atomic.LoadInt32(&d.draining) == 1
+}
+
+// Middleware for refuse requests with 503 while server is draining
+func drainHandler(service Service) routing.Handler {
+	return func(c *routing.Context) error {
+		if service.Drain.Draining() {
+			c.Response.Header().Set("Connection", "close")
+			return ErrShuttingDown
+		}
+		return nil
+	}
+}
+
+// Serve HTTP on listener until signal, then drain: for delay server keeps
+// accepting connections, but refuses requests with 503 and isn't ready, so
+// load balancers stop sending requests (another signal ends delay at once).
+// Then new connections are refused, in-flight requests get timeout to finish.
+// Returns after all handlers returned or timeout passed, so storage can be closed.
+func serve(server *http.Server, listener net.Listener, drain *Drain, delay time.Duration, timeout time.Duration, signals <-chan os.Signal) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %v, refusing requests for %v before shutdown", sig, delay)
+	}
+
+	drain.Start()
+	select {
+	case <-time.After(delay):
+	case sig := <-signals:
+		log.Printf("Received %v, shutting down at once", sig)
+	}
+
+	log.Printf("Draining requests for up to %v", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Shutdown:", err)
+		return err
+	}
+
+	log.Println("All requests are finished")
+	return nil
+}
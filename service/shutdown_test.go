@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDrainRefusesRequests(t *testing.T) {
+	drain := &Drain{}
+	server := httptest.NewServer(newRouter(Service{store: NewMemoryStore(), Drain: drain}))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/balance?playerId=P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, 400, res.StatusCode)
+
+	drain.Start()
+	res, err = http.Get(server.URL + "/balance?playerId=P1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiError APIError
+	json.NewDecoder(res.Body).Decode(&apiError)
+	res.Body.Close()
+	assert.Equal(t, 503, res.StatusCode)
+	assert.Equal(t, "shutting_down", apiError.Code)
+}
+
+// Start server with handler which waits for release
+func startBlockingServer(t *testing.T, timeout time.Duration) (string, chan os.Signal, chan struct{}, chan struct{}, chan error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte(`{}`))
+	})}
+
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(server, listener, &Drain{}, 0, timeout, signals)
+	}()
+	return "http://" + listener.Addr().String(), signals, started, release, done
+}
+
+func TestServeDrainsInFlightRequests(t *testing.T) {
+	url, signals, started, release, done := startBlockingServer(t, 5*time.Second)
+
+	responses := make(chan int, 1)
+	go func() {
+		res, err := http.Get(url)
+		if err != nil {
+			responses <- 0
+			return
+		}
+		res.Body.Close()
+		responses <- res.StatusCode
+	}()
+
+	<-started
+	signals <- syscall.SIGTERM
+
+	// Server waits for in-flight request
+	select {
+	case <-done:
+		t.Fatal("server stopped before request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// New connections are not accepted
+	_, err := http.Get(url)
+	assert.Error(t, err)
+
+	close(release)
+	assert.Equal(t, 200, <-responses)
+	assert.NoError(t, <-done)
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	url, signals, started, release, done := startBlockingServer(t, 50*time.Millisecond)
+	defer close(release)
+
+	go http.Get(url)
+	<-started
+	signals <- os.Interrupt
+
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+}
+
+func TestServeShutdownDelay(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + listener.Addr().String()
+
+	drain := &Drain{}
+	server := &http.Server{Handler: newRouter(Service{store: NewMemoryStore(), Drain: drain})}
+	signals := make(chan os.Signal, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(server, listener, drain, time.Minute, 5*time.Second, signals)
+	}()
+
+	get := func(path string) (int, string) {
+		res, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		var apiError APIError
+		json.NewDecoder(res.Body).Decode(&apiError)
+		return res.StatusCode, apiError.Code
+	}
+
+	signals <- syscall.SIGTERM
+	for deadline := time.Now().Add(5 * time.Second); !drain.Draining(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("server isn't draining")
+		}
+	}
+
+	// During delay requests are refused and server isn't ready
+	status, code := get("/balance?playerId=P1")
+	assert.Equal(t, 503, status)
+	assert.Equal(t, "shutting_down", code)
+	status, _ = get("/readyz")
+	assert.Equal(t, 503, status, "Readiness during delay")
+
+	select {
+	case <-done:
+		t.Fatal("server stopped before delay passed")
+	default:
+	}
+
+	// Another signal ends delay
+	signals <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server didn't stop after second signal")
+	}
+}
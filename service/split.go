@@ -0,0 +1,77 @@
+package main
+
+import "math/big"
+
+// Policies for remainder of integer division when deposit or prize
+// is split between player and backers
+const (
+	// Remainder is charged to (or paid to) the player
+	SplitPlayer = "player"
+
+	// Remainder is distributed by one point, starting from the player
+	SplitRoundRobin = "roundRobin"
+
+	// Remainder is covered by (or credited to) the house account
+	SplitHouse = "house"
+)
+
+// Policy for tournaments announced without explicit policy
+const defaultSplitPolicy = SplitPlayer
+
+// Check if split policy is known
+func validSplitPolicy(policy string) bool {
+	switch policy {
+	case SplitPlayer, SplitRoundRobin, SplitHouse:
+		return true
+	}
+	return false
+}
+
+// Split amount equally between participants, the first participant is the player.
+// Returns share of every participant and the part which goes to house,
+// sum of shares and house part is always equal to amount.
+func splitPoints(policy string, amount int64, participants int) ([]int64, int64) {
+	stakes := make([]int64, participants)
+	for i := range stakes {
+		stakes[i] = 1
+	}
+	return splitByStakes(policy, amount, stakes)
+}
+
+// Split amount proportionally to stakes, the first participant is the player.
+// Participants with zero stake get nothing, if all stakes are zero amount
+// is split equally. Remainder is allocated by the split policy.
+func splitByStakes(policy string, amount int64, stakes []int64) ([]int64, int64) {
+	var total int64
+	for _, stake := range stakes {
+		total += stake
+	}
+	if total == 0 {
+		return splitPoints(policy, amount, len(stakes))
+	}
+
+	// amount * stake may not fit into int64
+	shares := make([]int64, len(stakes))
+	remainder := amount
+	for i, stake := range stakes {
+		share := new(big.Int).Mul(big.NewInt(amount), big.NewInt(stake))
+		shares[i] = share.Quo(share, big.NewInt(total)).Int64()
+		remainder -= shares[i]
+	}
+
+	switch policy {
+	case SplitRoundRobin:
+		for i := 0; remainder > 0; i = (i + 1) % len(shares) {
+			if stakes[i] > 0 {
+				shares[i]++
+				remainder--
+			}
+		}
+	case SplitHouse:
+		return shares, remainder
+	default:
+		shares[0] += remainder
+	}
+
+	return shares, 0
+}
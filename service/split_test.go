@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSplitPoints(t *testing.T) {
+
+	cases := []struct {
+		policy string
+		amount int64
+		shares []int64
+		house  int64
+	}{
+		{SplitPlayer, 1000, []int64{334, 333, 333}, 0},
+		{SplitRoundRobin, 1000, []int64{334, 333, 333}, 0},
+		{SplitRoundRobin, 1001, []int64{334, 334, 333}, 0},
+		{SplitPlayer, 1001, []int64{335, 333, 333}, 0},
+		{SplitHouse, 1001, []int64{333, 333, 333}, 2},
+		{SplitHouse, 999, []int64{333, 333, 333}, 0},
+	}
+
+	for _, c := range cases {
+		shares, house := splitPoints(c.policy, c.amount, 3)
+		assert.Equal(t, c.shares, shares, "Shares for ", c.policy, c.amount)
+		assert.Equal(t, c.house, house, "House for ", c.policy, c.amount)
+	}
+}
+
+func TestJoinWithRemainder(t *testing.T) {
+
+	store := NewMemoryStore()
+	service := Service{store: store}
+
+	for _, p := range []string{"P1", "P2", "P3"} {
+		service.Fund(p, 1000)
+	}
+
+	// Deposit 1000 with 2 backers collects all 1000 points
+	assert.Nil(t, service.AnnounceTournament("1", 1000, SplitRoundRobin, true))
+	assert.Nil(t, service.JoinTournament("1", "P1", []string{"P2", "P3"}, nil))
+
+	balances := map[string]int64{"P1": 666, "P2": 667, "P3": 667}
+	for p, balance := range balances {
+		player, _ := service.PlayerBalance(p)
+		assert.Equal(t, balance, player.Balance, "Balance for ", p)
+	}
+
+	// Prize 1001 goes to house remainder
+	assert.Nil(t, service.AnnounceTournament("2", 0, SplitHouse, true))
+	assert.Nil(t, service.JoinTournament("2", "P1", []string{"P2", "P3"}, nil))
+	assert.Nil(t, service.ResultLegacyTournament("2", []Winner{{PlayerId: "P1", Prize: 1001}}))
+
+	store.Transactional(func(tx StoreTx) error {
+		balances, _ := tx.LedgerBalances()
+		assert.Equal(t, int64(2), balances[houseAccount], "House account")
+		assert.Equal(t, int64(-1001), balances[tournamentAccount("2")], "Tournament account")
+		return nil
+	})
+
+	assert.Equal(t, ErrInvalidSplitPolicy, service.AnnounceTournament("3", 1000, "random", true))
+}
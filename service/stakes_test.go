@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJoinWithStakes(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for _, p := range []string{"P1", "P2", "P3"} {
+		get(fmt.Sprintf("/fund?playerId=%s&points=1000", p))
+	}
+	get("/announceTournament?tournamentId=1&deposit=1000")
+
+	// Stakes must sum to deposit
+	res := get("/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3&playerStake=400&stake=500&stake=50")
+	assert.Equal(t, 400, res.StatusCode, "Stakes sum to 950")
+
+	// Stake is required for every backer
+	res = get("/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3&playerStake=400&stake=500")
+	assert.Equal(t, 400, res.StatusCode, "Stake for P3 is missing")
+
+	// P2 covers 50%, P3 covers 10%
+	res = get("/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3&playerStake=400&stake=500&stake=100")
+	assert.Equal(t, 200, res.StatusCode, "P1 joins backed by P2 and P3 with stakes")
+
+	body := `{"tournamentId": "1", "winners": [{"playerId": "P1", "prize": 2001}]}`
+	res, err := http.Post(server.URL+"/resultTournament", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 200, res.StatusCode, "P1 wins 2001")
+
+	// Prize is paid proportionally to stakes, remainder goes to player
+	balances := map[string]int64{"P1": 600 + 801, "P2": 500 + 1000, "P3": 900 + 200}
+	for p, balance := range balances {
+		var player Players
+		res := get("/balance?playerId=" + p)
+		json.NewDecoder(res.Body).Decode(&player)
+		res.Body.Close()
+		assert.Equal(t, balance, player.Balance, "Balance for ", p)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Store is a storage for players, tournaments and games used by Service.
+// All reads and writes go through Transactional, so implementations
+// decide how isolation and rollback are provided.
+type Store interface {
+	// Method for prepare storage (create tables, indexes, ...)
+	Initialize() error
+
+	// Method for reset storage to initial (empty) state
+	Reset() error
+
+	// Method for check storage is reachable
+	Ping(ctx context.Context) error
+
+	// Method for load version of applied schema migrations
+	SchemaVersion(ctx context.Context) (int, error)
+
+	// Method for run fn in a single transaction.
+	// If fn returns error all changes made through tx are rolled back.
+	Transactional(fn func(tx StoreTx) error) error
+}
+
+// Row lock of StoreTx.LockTournament and StoreTx.LockPlayer. Changes of
+// tournament status lock it for update, so concurrent changes wait for each
+// other; joins lock it for share, so they can't add games to tournament
+// being cancelled or finished.
+type LockMode int
+
+// Row locks
+const (
+	LockForUpdate LockMode = iota
+	LockForShare
+)
+
+// StoreTx is a set of storage operations available inside a transaction.
+// Lookups of missing rows return sql.ErrNoRows for every implementation.
+type StoreTx interface {
+	// Method for load player by id
+	Player(id string) (Players, error)
+
+	// Method for load player by id and lock it until the end of
+	// transaction, lock is LockForUpdate or LockForShare
+	LockPlayer(id string, lock LockMode) (Players, error)
+
+	// Method for load all players ordered by id
+	Players() ([]Players, error)
+
+	// Method for add points to player, player is created if doesn't exist
+	FundPlayer(id string, points int64) error
+
+	// Method for take points from player if balance is enough,
+	// returns number of updated players (0 or 1)
+	TakePlayer(id string, points int64) (int64, error)
+
+	// Method for add points to existing player,
+	// returns number of updated players (0 or 1)
+	CreditPlayer(id string, points int64) (int64, error)
+
+	// Method for insert new player
+	InsertPlayer(player Players) error
+
+	// Method for change status of player
+	UpdatePlayerStatus(id string, status string) error
+
+	// Method for insert new tournament
+	InsertTournament(tournament Tournaments) error
+
+	// Method for load tournament by id
+	Tournament(id string) (Tournaments, error)
+
+	// Method for load tournament by id and lock it until the end of
+	// transaction, lock is LockForUpdate or LockForShare
+	LockTournament(id string, lock LockMode) (Tournaments, error)
+
+	// Method for load tournaments matching filter, ordered by creation time and id,
+	// returns at most filter.Limit tournaments
+	ListTournaments(filter TournamentFilter) ([]Tournaments, error)
+
+	// Method for change status of tournament
+	UpdateTournamentStatus(id string, status string) error
+
+	// Method for insert player (with backers) into tournament,
+	// returns id of inserted game
+	InsertGame(game Games) (int64, error)
+
+	// Method for load game of player in tournament
+	Game(tournamentID string, playerID string) (Games, error)
+
+	// Method for load all games of tournament, ordered by id
+	Games(tournamentID string) ([]Games, error)
+
+	// Method for delete game of player in tournament
+	DeleteGame(tournamentID string, playerID string) error
+
+	// Method for append movement entries to ledger
+	InsertMovement(movement Movement) error
+
+	// Method for load ledger entries of tournament movements, ordered by id
+	TournamentLedger(tournamentID string) ([]LedgerEntry, error)
+
+	// Method for load ledger entries of player matching filter with running
+	// balance, newest first, returns at most filter.Limit entries
+	PlayerHistory(filter HistoryFilter) ([]HistoryEntry, error)
+
+	// Method for sum ledger entries amounts by account
+	LedgerBalances() (map[string]int64, error)
+
+	// Method for load idempotency record by key
+	IdempotencyRecord(key string) (IdempotencyRecord, error)
+
+	// Method for insert idempotency record if key isn't used yet,
+	// returns number of inserted records (0 or 1)
+	InsertIdempotencyRecord(record IdempotencyRecord) (int64, error)
+
+	// Method for update status, body and time of idempotency record
+	UpdateIdempotencyRecord(record IdempotencyRecord) error
+
+	// Method for delete idempotency record by key
+	DeleteIdempotencyRecord(key string) error
+
+	// Method for insert new API key
+	InsertAPIKey(key APIKey) error
+
+	// Method for load API key by id
+	APIKey(id string) (APIKey, error)
+
+	// Method for load all API keys ordered by creation time
+	APIKeys() ([]APIKey, error)
+
+	// Method for check any API key was ever issued, revoked keys count too
+	HasAPIKeys() (bool, error)
+
+	// Method for mark API key as revoked
+	RevokeAPIKey(id string, revokedAt time.Time) error
+}
+
+// Structure (Model) for player participation in tournament.
+// Stakes are points paid on join: Stakes[0] by player,
+// Stakes[i+1] by Backers[i].
+type Games struct {
+	ID           int64
+	TournamentID string
+	PlayerID     string
+	Backers      []string
+	Stakes       []int64
+}
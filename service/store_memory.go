@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store which keeps everything in process memory.
+// Transactions are serialized: each one works on a private copy of the
+// state, which replaces the shared state only when the transaction succeeds.
+type MemoryStore struct {
+	mu    sync.Mutex
+	state *memoryState
+}
+
+// Create empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{state: newMemoryState()}
+}
+
+// Method for prepare storage, nothing to do for in-memory store
+func (store *MemoryStore) Initialize() error {
+	return nil
+}
+
+// Method for reset storage to initial state
+func (store *MemoryStore) Reset() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// API keys are not game data, they survive reset
+	apiKeys := store.state.apiKeys
+	store.state = newMemoryState()
+	store.state.apiKeys = apiKeys
+	return nil
+}
+
+// Method for check storage is reachable, memory is always reachable
+func (store *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Method for load schema version, memory has no migrations to apply
+func (store *MemoryStore) SchemaVersion(ctx context.Context) (int, error) {
+	return latestSchemaVersion(), nil
+}
+
+// Method for run fn on a copy of the state and keep the copy on success
+func (store *MemoryStore) Transactional(fn func(tx StoreTx) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	tx := &memoryTx{state: store.state.clone()}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	store.state = tx.state
+	return nil
+}
+
+// Key of game, (tournamentId, playerId) is unique
+type memoryGameKey struct {
+	tournamentID string
+	playerID     string
+}
+
+// Full state of in-memory store
+type memoryState struct {
+	players     map[string]Players
+	tournaments map[string]Tournaments
+	games       map[memoryGameKey]Games
+	gamesSeq    int64
+	ledger      []LedgerEntry
+	movementSeq int64
+	idempotency map[string]IdempotencyRecord
+	apiKeys     map[string]APIKey
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{
+		players:     map[string]Players{},
+		tournaments: map[string]Tournaments{},
+		games:       map[memoryGameKey]Games{},
+		idempotency: map[string]IdempotencyRecord{},
+		apiKeys:     map[string]APIKey{},
+	}
+}
+
+// Method for deep copy of the state
+func (state *memoryState) clone() *memoryState {
+	c := newMemoryState()
+	for id, player := range state.players {
+		c.players[id] = player
+	}
+	for id, tournament := range state.tournaments {
+		c.tournaments[id] = tournament
+	}
+	for key, game := range state.games {
+		game.Backers = append([]string(nil), game.Backers...)
+		game.Stakes = append([]int64(nil), game.Stakes...)
+		c.games[key] = game
+	}
+	c.gamesSeq = state.gamesSeq
+	// Ledger is append-only, entries are never changed
+	c.ledger = state.ledger[:len(state.ledger):len(state.ledger)]
+	c.movementSeq = state.movementSeq
+	for key, record := range state.idempotency {
+		c.idempotency[key] = record
+	}
+	for id, key := range state.apiKeys {
+		c.apiKeys[id] = key
+	}
+	return c
+}
+
+// memoryTx implements StoreTx on a private copy of the state
+type memoryTx struct {
+	state *memoryState
+}
+
+// Method for load player by id
+func (tx *memoryTx) Player(id string) (Players, error) {
+	player, ok := tx.state.players[id]
+	if !ok {
+		return Players{}, sql.ErrNoRows
+	}
+	return player, nil
+}
+
+// Method for load player by id, transactions of memory store are
+// serialized, so there is nothing to lock
+func (tx *memoryTx) LockPlayer(id string, lock LockMode) (Players, error) {
+	return tx.Player(id)
+}
+
+// Method for load all players ordered by id
+func (tx *memoryTx) Players() ([]Players, error) {
+	players := make([]Players, 0, len(tx.state.players))
+	for _, player := range tx.state.players {
+		players = append(players, player)
+	}
+	sort.Slice(players, func(i, j int) bool { return players[i].ID < players[j].ID })
+	return players, nil
+}
+
+// Method for fund player with points, player is created if doesn't exist
+func (tx *memoryTx) FundPlayer(id string, points int64) error {
+	player, ok := tx.state.players[id]
+	if !ok {
+		player = Players{ID: id, Status: PlayerActive}
+	}
+	player.Balance += points
+	tx.state.players[id] = player
+	return nil
+}
+
+// Method for take points from player if balance is enough
+func (tx *memoryTx) TakePlayer(id string, points int64) (int64, error) {
+	player, ok := tx.state.players[id]
+	if !ok || player.Balance < points {
+		return 0, nil
+	}
+	player.Balance -= points
+	tx.state.players[id] = player
+	return 1, nil
+}
+
+// Method for add points to existing player
+func (tx *memoryTx) CreditPlayer(id string, points int64) (int64, error) {
+	player, ok := tx.state.players[id]
+	if !ok {
+		return 0, nil
+	}
+	player.Balance += points
+	tx.state.players[id] = player
+	return 1, nil
+}
+
+// Method for insert new player
+func (tx *memoryTx) InsertPlayer(player Players) error {
+	if _, ok := tx.state.players[player.ID]; ok {
+		return errors.New("duplicate player id")
+	}
+	tx.state.players[player.ID] = player
+	return nil
+}
+
+// Method for change status of player
+func (tx *memoryTx) UpdatePlayerStatus(id string, status string) error {
+	player, ok := tx.state.players[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	player.Status = status
+	tx.state.players[id] = player
+	return nil
+}
+
+// Method for insert new tournament
+func (tx *memoryTx) InsertTournament(tournament Tournaments) error {
+	if _, ok := tx.state.tournaments[tournament.ID]; ok {
+		return errors.New("duplicate tournament id")
+	}
+	tx.state.tournaments[tournament.ID] = tournament
+	return nil
+}
+
+// Method for load tournament by id
+func (tx *memoryTx) Tournament(id string) (Tournaments, error) {
+	tournament, ok := tx.state.tournaments[id]
+	if !ok {
+		return Tournaments{}, sql.ErrNoRows
+	}
+	return tournament, nil
+}
+
+// Method for load tournament by id, transactions of memory store are
+// serialized, so there is nothing to lock
+func (tx *memoryTx) LockTournament(id string, lock LockMode) (Tournaments, error) {
+	return tx.Tournament(id)
+}
+
+// Method for load tournaments matching filter, ordered by creation time and id
+func (tx *memoryTx) ListTournaments(filter TournamentFilter) ([]Tournaments, error) {
+	var tournaments []Tournaments
+	for _, tournament := range tx.state.tournaments {
+		if filter.Match(tournament) && tx.joined(tournament.ID, filter.PlayerID) {
+			tournaments = append(tournaments, tournament)
+		}
+	}
+
+	sort.Slice(tournaments, func(i, j int) bool { return tournamentBefore(tournaments[i], tournaments[j]) })
+	if len(tournaments) > filter.Limit {
+		tournaments = tournaments[:filter.Limit]
+	}
+	return tournaments, nil
+}
+
+// Check if player (or backer) joined tournament, any player matches empty id
+func (tx *memoryTx) joined(tournamentID string, playerID string) bool {
+	if playerID == "" {
+		return true
+	}
+	for key, game := range tx.state.games {
+		if key.tournamentID != tournamentID {
+			continue
+		}
+		if game.PlayerID == playerID {
+			return true
+		}
+		for _, backer := range game.Backers {
+			if backer == playerID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Method for change status of tournament
+func (tx *memoryTx) UpdateTournamentStatus(id string, status string) error {
+	tournament, ok := tx.state.tournaments[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	tournament.Status = status
+	tx.state.tournaments[id] = tournament
+	return nil
+}
+
+// Method for insert player (with backers) into tournament
+func (tx *memoryTx) InsertGame(game Games) (int64, error) {
+	key := memoryGameKey{game.TournamentID, game.PlayerID}
+	if _, ok := tx.state.games[key]; ok {
+		return 0, errors.New("duplicate game for tournament and player")
+	}
+	tx.state.gamesSeq++
+	game.ID = tx.state.gamesSeq
+	game.Backers = append([]string(nil), game.Backers...)
+	game.Stakes = append([]int64(nil), game.Stakes...)
+	tx.state.games[key] = game
+	return game.ID, nil
+}
+
+// Method for load game of player in tournament
+func (tx *memoryTx) Game(tournamentID string, playerID string) (Games, error) {
+	game, ok := tx.state.games[memoryGameKey{tournamentID, playerID}]
+	if !ok {
+		return Games{}, sql.ErrNoRows
+	}
+	game.Backers = append([]string(nil), game.Backers...)
+	game.Stakes = append([]int64(nil), game.Stakes...)
+	return game, nil
+}
+
+// Method for load all games of tournament, ordered by id
+func (tx *memoryTx) Games(tournamentID string) ([]Games, error) {
+	var games []Games
+	for key, game := range tx.state.games {
+		if key.tournamentID != tournamentID {
+			continue
+		}
+		game.Backers = append([]string(nil), game.Backers...)
+		game.Stakes = append([]int64(nil), game.Stakes...)
+		games = append(games, game)
+	}
+	sort.Slice(games, func(i, j int) bool { return games[i].ID < games[j].ID })
+	return games, nil
+}
+
+// Method for delete game of player in tournament
+func (tx *memoryTx) DeleteGame(tournamentID string, playerID string) error {
+	key := memoryGameKey{tournamentID, playerID}
+	if _, ok := tx.state.games[key]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(tx.state.games, key)
+	return nil
+}
+
+// Method for append movement entries to ledger
+func (tx *memoryTx) InsertMovement(movement Movement) error {
+	tx.state.movementSeq++
+	now := time.Now().UTC()
+
+	for _, entry := range movement.Entries {
+		entry.ID = int64(len(tx.state.ledger)) + 1
+		entry.MovementID = tx.state.movementSeq
+		entry.Kind = movement.Kind
+		entry.TournamentID = movement.TournamentID
+		entry.GameID = movement.GameID
+		entry.CreatedAt = now
+		tx.state.ledger = append(tx.state.ledger, entry)
+	}
+	return nil
+}
+
+// Method for load ledger entries of tournament movements, ordered by id
+func (tx *memoryTx) TournamentLedger(tournamentID string) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+	for _, entry := range tx.state.ledger {
+		if entry.TournamentID == tournamentID {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// Method for load ledger entries of player matching filter with running
+// balance, newest first, returns at most filter.Limit entries
+func (tx *memoryTx) PlayerHistory(filter HistoryFilter) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+	var balance int64
+	for _, entry := range tx.state.ledger {
+		if entry.Account != playerAccount(filter.PlayerID) {
+			continue
+		}
+		balance += entry.Amount
+
+		h := HistoryEntry{
+			ID:           entry.ID,
+			MovementID:   entry.MovementID,
+			Kind:         entry.Kind,
+			Amount:       entry.Amount,
+			Balance:      balance,
+			TournamentID: entry.TournamentID,
+			GameID:       entry.GameID,
+			CreatedAt:    entry.CreatedAt,
+		}
+		if filter.Match(h) {
+			entries = append(entries, h)
+		}
+	}
+
+	// Newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// Method for sum ledger entries amounts by account
+func (tx *memoryTx) LedgerBalances() (map[string]int64, error) {
+	balances := map[string]int64{}
+	for _, entry := range tx.state.ledger {
+		balances[entry.Account] += entry.Amount
+	}
+	return balances, nil
+}
+
+// Method for load idempotency record by key
+func (tx *memoryTx) IdempotencyRecord(key string) (IdempotencyRecord, error) {
+	record, ok := tx.state.idempotency[key]
+	if !ok {
+		return IdempotencyRecord{}, sql.ErrNoRows
+	}
+	return record, nil
+}
+
+// Method for insert idempotency record if key isn't used yet
+func (tx *memoryTx) InsertIdempotencyRecord(record IdempotencyRecord) (int64, error) {
+	if _, ok := tx.state.idempotency[record.Key]; ok {
+		return 0, nil
+	}
+	tx.state.idempotency[record.Key] = record
+	return 1, nil
+}
+
+// Method for update idempotency record
+func (tx *memoryTx) UpdateIdempotencyRecord(record IdempotencyRecord) error {
+	if _, ok := tx.state.idempotency[record.Key]; !ok {
+		return sql.ErrNoRows
+	}
+	tx.state.idempotency[record.Key] = record
+	return nil
+}
+
+// Method for delete idempotency record by key
+func (tx *memoryTx) DeleteIdempotencyRecord(key string) error {
+	if _, ok := tx.state.idempotency[key]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(tx.state.idempotency, key)
+	return nil
+}
+
+// Method for insert new API key
+func (tx *memoryTx) InsertAPIKey(key APIKey) error {
+	if _, ok := tx.state.apiKeys[key.ID]; ok {
+		return errors.New("duplicate API key id")
+	}
+	tx.state.apiKeys[key.ID] = key
+	return nil
+}
+
+// Method for load API key by id
+func (tx *memoryTx) APIKey(id string) (APIKey, error) {
+	key, ok := tx.state.apiKeys[id]
+	if !ok {
+		return APIKey{}, sql.ErrNoRows
+	}
+	return key, nil
+}
+
+// Method for load all API keys ordered by creation time
+func (tx *memoryTx) APIKeys() ([]APIKey, error) {
+	keys := make([]APIKey, 0, len(tx.state.apiKeys))
+	for _, key := range tx.state.apiKeys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// Method for check any API key was ever issued
+func (tx *memoryTx) HasAPIKeys() (bool, error) {
+	return len(tx.state.apiKeys) > 0, nil
+}
+
+// Method for mark API key as revoked
+func (tx *memoryTx) RevokeAPIKey(id string, revokedAt time.Time) error {
+	key, ok := tx.state.apiKeys[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	key.RevokedAt = &revokedAt
+	tx.state.apiKeys[id] = key
+	return nil
+}
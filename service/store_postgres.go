@@ -0,0 +1,647 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/lib/pq"
+	"log"
+	"time"
+)
+
+// PostgresStore is a Store backed by PostgreSQL database
+type PostgresStore struct {
+	db *dbx.DB
+
+	// Apply pending migrations on Initialize
+	AutoMigrate bool
+}
+
+// Create PostgreSQL store for opened database
+func NewPostgresStore(db *dbx.DB) *PostgresStore {
+	return &PostgresStore{db: db, AutoMigrate: true}
+}
+
+// Method for check database connection
+func (store *PostgresStore) Ping(ctx context.Context) error {
+	return store.db.DB().PingContext(ctx)
+}
+
+// Method for connection pool statistics
+func (store *PostgresStore) DBStats() sql.DBStats {
+	return store.db.DB().Stats()
+}
+
+// Method for check schema version and apply pending migrations.
+// Refuses to work with schema migrated by a newer build.
+func (store *PostgresStore) Initialize() error {
+	version, err := store.SchemaVersion(context.Background())
+	if err != nil {
+		return err
+	}
+
+	latest := latestSchemaVersion()
+	if version > latest {
+		return fmt.Errorf("database schema version %d is newer than supported %d", version, latest)
+	}
+
+	if version < latest && !store.AutoMigrate {
+		log.Printf("Database schema version %d, latest is %d, run migrate command", version, latest)
+		return nil
+	}
+
+	return store.Migrate(latest)
+}
+
+const truncateSQL = `
+	TRUNCATE games;
+	TRUNCATE tournaments;
+	TRUNCATE players;
+	TRUNCATE ledger;
+	TRUNCATE idempotency_keys;
+`
+
+// API keys are not game data, they survive reset
+
+// Method for reset DB for initial state
+func (store *PostgresStore) Reset() error {
+	q := store.db.NewQuery(truncateSQL)
+	_, err := q.Execute()
+
+	return err
+}
+
+// Method for run fn in database transaction
+func (store *PostgresStore) Transactional(fn func(tx StoreTx) error) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Rollback on panic, and pass panic further
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(&postgresTx{db: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// postgresTx implements StoreTx on top of dbx transaction
+type postgresTx struct {
+	db dbx.Builder
+}
+
+// Method for load player by id
+func (tx *postgresTx) Player(id string) (Players, error) {
+	var player Players
+	err := tx.db.Select("id", "balance", "status").
+		From("players").
+		Where(dbx.HashExp{"id": id}).
+		One(&player)
+	return player, err
+}
+
+const lockPlayerSQL = `
+    SELECT id, balance, status
+    FROM players
+    WHERE id = {:id}
+`
+
+// Method for load player by id and lock its row
+func (tx *postgresTx) LockPlayer(id string, lock LockMode) (Players, error) {
+	var player Players
+	clause, err := lockSQL(lock)
+	if err != nil {
+		return player, err
+	}
+	err = tx.db.NewQuery(lockPlayerSQL + clause).
+		Bind(dbx.Params{"id": id}).
+		One(&player)
+	return player, err
+}
+
+// Method for load all players ordered by id
+func (tx *postgresTx) Players() ([]Players, error) {
+	var players []Players
+	err := tx.db.Select("id", "balance", "status").
+		From("players").
+		OrderBy("id").
+		All(&players)
+	return players, err
+}
+
+const fundSQL = `
+	INSERT INTO players
+    		(id, balance)
+	VALUES
+    		({:id}, {:points})
+	ON
+ 		CONFLICT (id)
+	DO UPDATE SET
+    		balance = players.balance + {:points}
+
+`
+
+// Method for fund player with points
+// Add playerId into database, if player doesn't exist
+func (tx *postgresTx) FundPlayer(id string, points int64) error {
+	q := tx.db.NewQuery(fundSQL)
+	q.Bind(dbx.Params{
+		"id":     id,
+		"points": points,
+	})
+
+	_, err := q.Execute()
+	return err
+}
+
+const takeSQL = `
+        UPDATE players
+	SET balance = balance - {:points}
+	WHERE
+		id = {:id}
+		AND balance >= {:points}
+`
+
+// Method for take points from player
+func (tx *postgresTx) TakePlayer(id string, points int64) (int64, error) {
+	return tx.execute(takeSQL, dbx.Params{
+		"id":     id,
+		"points": points,
+	})
+}
+
+const prizeSQL = `
+    UPDATE players
+    SET balance = balance + {:points}
+    WHERE id = {:id}
+`
+
+// Method for add points to existing player
+func (tx *postgresTx) CreditPlayer(id string, points int64) (int64, error) {
+	return tx.execute(prizeSQL, dbx.Params{
+		"id":     id,
+		"points": points,
+	})
+}
+
+// Method for insert player into database
+func (tx *postgresTx) InsertPlayer(player Players) error {
+	return tx.db.Model(&player).Insert()
+}
+
+// Method for change status of player
+func (tx *postgresTx) UpdatePlayerStatus(id string, status string) error {
+	_, err := tx.db.Update("players", dbx.Params{
+		"status": status,
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+// Method for insert tournament into database
+func (tx *postgresTx) InsertTournament(tournament Tournaments) error {
+	return tx.db.Model(&tournament).Insert()
+}
+
+// Method for load tournament by id
+func (tx *postgresTx) Tournament(id string) (Tournaments, error) {
+	var tournament Tournaments
+	err := tx.db.Select("id", "deposit", "status", "split_policy", "created_at").
+		From("tournaments").
+		Where(dbx.HashExp{"id": id}).
+		One(&tournament)
+	return tournament, err
+}
+
+const lockTournamentSQL = `
+    SELECT id, deposit, status, split_policy, created_at
+    FROM tournaments
+    WHERE id = {:id}
+`
+
+// Locking clause of row lock
+func lockSQL(lock LockMode) (string, error) {
+	switch lock {
+	case LockForUpdate:
+		return " FOR UPDATE", nil
+	case LockForShare:
+		return " FOR SHARE", nil
+	}
+	return "", fmt.Errorf("unknown lock mode %d", lock)
+}
+
+// Method for load tournament by id and lock its row
+func (tx *postgresTx) LockTournament(id string, lock LockMode) (Tournaments, error) {
+	var tournament Tournaments
+	clause, err := lockSQL(lock)
+	if err != nil {
+		return tournament, err
+	}
+	err = tx.db.NewQuery(lockTournamentSQL + clause).
+		Bind(dbx.Params{"id": id}).
+		One(&tournament)
+	return tournament, err
+}
+
+// Method for load tournaments matching filter, ordered by creation time and id
+func (tx *postgresTx) ListTournaments(filter TournamentFilter) ([]Tournaments, error) {
+	var where []dbx.Expression
+
+	if len(filter.Statuses) > 0 {
+		statuses := make([]interface{}, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			statuses[i] = status
+		}
+		where = append(where, dbx.In("status", statuses...))
+	}
+	if filter.MinDeposit != nil {
+		where = append(where, dbx.NewExp("deposit >= {:minDeposit}", dbx.Params{"minDeposit": *filter.MinDeposit}))
+	}
+	if filter.MaxDeposit != nil {
+		where = append(where, dbx.NewExp("deposit <= {:maxDeposit}", dbx.Params{"maxDeposit": *filter.MaxDeposit}))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		where = append(where, dbx.NewExp("created_at >= {:createdFrom}", dbx.Params{"createdFrom": filter.CreatedFrom}))
+	}
+	if !filter.CreatedTo.IsZero() {
+		where = append(where, dbx.NewExp("created_at < {:createdTo}", dbx.Params{"createdTo": filter.CreatedTo}))
+	}
+	if filter.PlayerID != "" {
+		where = append(where, dbx.NewExp(`EXISTS (
+			SELECT 1 FROM games
+			WHERE
+				games.tournament_id = tournaments.id
+				AND (games.player_id = {:playerId} OR {:playerId} = ANY(games.backers))
+		)`, dbx.Params{"playerId": filter.PlayerID}))
+	}
+	if filter.After != nil {
+		where = append(where, dbx.NewExp("(created_at, id) > ({:afterCreatedAt}, {:afterId})", dbx.Params{
+			"afterCreatedAt": filter.After.CreatedAt,
+			"afterId":        filter.After.ID,
+		}))
+	}
+
+	var tournaments []Tournaments
+	err := tx.db.Select("id", "deposit", "status", "split_policy", "created_at").
+		From("tournaments").
+		Where(dbx.And(where...)).
+		OrderBy("created_at", "id").
+		Limit(int64(filter.Limit)).
+		All(&tournaments)
+	return tournaments, err
+}
+
+// Method for change status of tournament
+func (tx *postgresTx) UpdateTournamentStatus(id string, status string) error {
+	_, err := tx.db.Update("tournaments", dbx.Params{
+		"status": status,
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
+
+const insertGameSQL = `
+    INSERT INTO games
+        (tournament_id, player_id, backers, stakes)
+    VALUES
+        ({:tournamentId}, {:playerId}, {:backers}, {:stakes})
+    RETURNING id
+`
+
+// Method for save player with backers to database
+func (tx *postgresTx) InsertGame(game Games) (int64, error) {
+	var id int64
+
+	q := tx.db.NewQuery(insertGameSQL)
+	q.Bind(dbx.Params{
+		"tournamentId": game.TournamentID,
+		"playerId":     game.PlayerID,
+		"backers":      pq.Array(game.Backers),
+		"stakes":       pq.Array(game.Stakes),
+	})
+	err := q.Row(&id)
+
+	return id, err
+}
+
+const gameSQL = `
+    SELECT
+        id,
+        player_id,
+        backers,
+        stakes
+    FROM games
+    WHERE
+         tournament_id = {:tournamentId}
+         AND player_id = {:playerId}
+    LIMIT 1
+`
+
+// Method for load game of player in tournament
+func (tx *postgresTx) Game(tournamentID string, playerID string) (Games, error) {
+	game := Games{TournamentID: tournamentID}
+
+	q := tx.db.NewQuery(gameSQL)
+	q.Bind(dbx.Params{
+		"tournamentId": tournamentID,
+		"playerId":     playerID,
+	})
+	err := q.Row(&game.ID, &game.PlayerID, pq.Array(&game.Backers), pq.Array(&game.Stakes))
+
+	return game, err
+}
+
+const gamesSQL = `
+    SELECT
+        id,
+        player_id,
+        backers,
+        stakes
+    FROM games
+    WHERE
+         tournament_id = {:tournamentId}
+    ORDER BY id
+`
+
+// Method for load all games of tournament, ordered by id
+func (tx *postgresTx) Games(tournamentID string) ([]Games, error) {
+	q := tx.db.NewQuery(gamesSQL)
+	q.Bind(dbx.Params{"tournamentId": tournamentID})
+
+	rows, err := q.Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var games []Games
+	for rows.Next() {
+		game := Games{TournamentID: tournamentID}
+		err := rows.Scan(&game.ID, &game.PlayerID, pq.Array(&game.Backers), pq.Array(&game.Stakes))
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+	return games, rows.Err()
+}
+
+// Method for delete game of player in tournament
+func (tx *postgresTx) DeleteGame(tournamentID string, playerID string) error {
+	_, err := tx.db.Delete("games", dbx.HashExp{
+		"tournament_id": tournamentID,
+		"player_id":     playerID,
+	}).Execute()
+	return err
+}
+
+// Method for append movement entries to ledger
+func (tx *postgresTx) InsertMovement(movement Movement) error {
+	var movementID int64
+	err := tx.db.NewQuery(`SELECT nextval('ledger_movement_seq')`).Row(&movementID)
+	if err != nil {
+		return err
+	}
+
+	// Movements outside tournaments have NULL tournament and game
+	var tournamentID *string
+	var gameID *int64
+	if movement.TournamentID != "" {
+		tournamentID = &movement.TournamentID
+	}
+	if movement.GameID != 0 {
+		gameID = &movement.GameID
+	}
+
+	for _, entry := range movement.Entries {
+		_, err := tx.db.Insert("ledger", dbx.Params{
+			"movement_id":   movementID,
+			"kind":          movement.Kind,
+			"account":       entry.Account,
+			"amount":        entry.Amount,
+			"tournament_id": tournamentID,
+			"game_id":       gameID,
+		}).Execute()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+const tournamentLedgerSQL = `
+    SELECT
+        id,
+        movement_id,
+        kind,
+        account,
+        amount,
+        tournament_id,
+        COALESCE(game_id, 0) AS game_id,
+        created_at
+    FROM ledger
+    WHERE
+        tournament_id = {:tournamentId}
+    ORDER BY id
+`
+
+// Method for load ledger entries of tournament movements, ordered by id
+func (tx *postgresTx) TournamentLedger(tournamentID string) ([]LedgerEntry, error) {
+	var entries []LedgerEntry
+
+	q := tx.db.NewQuery(tournamentLedgerSQL)
+	q.Bind(dbx.Params{"tournamentId": tournamentID})
+	err := q.All(&entries)
+
+	return entries, err
+}
+
+// Running balance is calculated over all entries of player, before filter
+const playerHistorySQL = `
+    SELECT
+        id,
+        movement_id,
+        kind,
+        amount,
+        balance,
+        COALESCE(tournament_id, '') AS tournament_id,
+        COALESCE(game_id, 0) AS game_id,
+        created_at
+    FROM (
+        SELECT
+            *,
+            sum(amount) OVER (ORDER BY id) AS balance
+        FROM ledger
+        WHERE account = {:account}
+    ) history
+    WHERE
+        (cardinality({:kinds}::text[]) = 0 OR kind = ANY({:kinds}::text[]))
+        AND ({:createdFrom}::timestamptz IS NULL OR created_at >= {:createdFrom}::timestamptz)
+        AND ({:createdTo}::timestamptz IS NULL OR created_at < {:createdTo}::timestamptz)
+        AND ({:before} = 0 OR id < {:before})
+    ORDER BY id DESC
+    LIMIT {:limit}
+`
+
+// Method for load ledger entries of player matching filter with running
+// balance, newest first, returns at most filter.Limit entries
+func (tx *postgresTx) PlayerHistory(filter HistoryFilter) ([]HistoryEntry, error) {
+	var entries []HistoryEntry
+
+	// Zero time is passed as NULL and no kinds as empty array, so they don't filter
+	var createdFrom, createdTo *time.Time
+	if !filter.CreatedFrom.IsZero() {
+		createdFrom = &filter.CreatedFrom
+	}
+	if !filter.CreatedTo.IsZero() {
+		createdTo = &filter.CreatedTo
+	}
+
+	q := tx.db.NewQuery(playerHistorySQL)
+	q.Bind(dbx.Params{
+		"account":     playerAccount(filter.PlayerID),
+		"kinds":       pq.Array(append([]string{}, filter.Kinds...)),
+		"createdFrom": createdFrom,
+		"createdTo":   createdTo,
+		"before":      filter.Before,
+		"limit":       filter.Limit,
+	})
+	err := q.All(&entries)
+
+	return entries, err
+}
+
+// Method for sum ledger entries amounts by account
+func (tx *postgresTx) LedgerBalances() (map[string]int64, error) {
+	var rows []struct {
+		Account string `db:"account"`
+		Balance int64  `db:"balance"`
+	}
+
+	err := tx.db.NewQuery(`SELECT account, sum(amount) AS balance FROM ledger GROUP BY account`).All(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		balances[row.Account] = row.Balance
+	}
+	return balances, nil
+}
+
+// Method for load idempotency record by key
+func (tx *postgresTx) IdempotencyRecord(key string) (IdempotencyRecord, error) {
+	var record IdempotencyRecord
+	err := tx.db.Select("key", "fingerprint", "status", "body", "created_at").
+		From("idempotency_keys").
+		Where(dbx.HashExp{"key": key}).
+		One(&record)
+	return record, err
+}
+
+const insertIdempotencySQL = `
+    INSERT INTO idempotency_keys
+        (key, fingerprint, created_at)
+    VALUES
+        ({:key}, {:fingerprint}, {:createdAt})
+    ON CONFLICT (key) DO NOTHING
+`
+
+// Method for insert idempotency record if key isn't used yet
+func (tx *postgresTx) InsertIdempotencyRecord(record IdempotencyRecord) (int64, error) {
+	return tx.execute(insertIdempotencySQL, dbx.Params{
+		"key":         record.Key,
+		"fingerprint": record.Fingerprint,
+		"createdAt":   record.CreatedAt,
+	})
+}
+
+// Method for update idempotency record
+func (tx *postgresTx) UpdateIdempotencyRecord(record IdempotencyRecord) error {
+	_, err := tx.db.Update("idempotency_keys", dbx.Params{
+		"status":     record.Status,
+		"body":       record.Body,
+		"created_at": record.CreatedAt,
+	}, dbx.HashExp{"key": record.Key}).Execute()
+	return err
+}
+
+// Method for delete idempotency record by key
+func (tx *postgresTx) DeleteIdempotencyRecord(key string) error {
+	_, err := tx.db.Delete("idempotency_keys", dbx.HashExp{"key": key}).Execute()
+	return err
+}
+
+// Method for execute update query and return number of affected rows
+func (tx *postgresTx) execute(sql string, params dbx.Params) (int64, error) {
+	q := tx.db.NewQuery(sql)
+	q.Bind(params)
+
+	result, err := q.Execute()
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Method for insert new API key
+func (tx *postgresTx) InsertAPIKey(key APIKey) error {
+	_, err := tx.db.Insert("api_keys", dbx.Params{
+		"id":         key.ID,
+		"hash":       key.Hash,
+		"role":       key.Role,
+		"name":       key.Name,
+		"created_at": key.CreatedAt,
+	}).Execute()
+	return err
+}
+
+// Method for load API key by id
+func (tx *postgresTx) APIKey(id string) (APIKey, error) {
+	var key APIKey
+	err := tx.db.Select("id", "hash", "role", "name", "created_at", "revoked_at").
+		From("api_keys").
+		Where(dbx.HashExp{"id": id}).
+		One(&key)
+	return key, err
+}
+
+// Method for load all API keys ordered by creation time
+func (tx *postgresTx) APIKeys() ([]APIKey, error) {
+	var keys []APIKey
+	err := tx.db.Select("id", "hash", "role", "name", "created_at", "revoked_at").
+		From("api_keys").
+		OrderBy("created_at").
+		All(&keys)
+	return keys, err
+}
+
+const hasAPIKeysSQL = `
+    SELECT EXISTS (SELECT 1 FROM api_keys)
+`
+
+// Method for check any API key was ever issued
+func (tx *postgresTx) HasAPIKeys() (bool, error) {
+	var exists bool
+	err := tx.db.NewQuery(hasAPIKeysSQL).Row(&exists)
+	return exists, err
+}
+
+// Method for mark API key as revoked
+func (tx *postgresTx) RevokeAPIKey(id string, revokedAt time.Time) error {
+	_, err := tx.db.Update("api_keys", dbx.Params{
+		"revoked_at": revokedAt,
+	}, dbx.HashExp{"id": id}).Execute()
+	return err
+}
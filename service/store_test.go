@@ -0,0 +1,80 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// Empty store for tests: PostgreSQL database from SQL_DB if it's set,
+// memory otherwise. Close it after test.
+func newTestStore(t *testing.T) (Store, func()) {
+	dsn := os.Getenv("SQL_DB")
+	if dsn == "" {
+		return NewMemoryStore(), func() {}
+	}
+
+	store, closeStore := initStore(DatabaseConfig{
+		Storage:      StoragePostgres,
+		DSN:          dsn,
+		MaxIdleConns: 2,
+		AutoMigrate:  true,
+	})
+	if err := store.Initialize(); err != nil {
+		closeStore()
+		t.Fatal(err)
+	}
+	if err := store.Reset(); err != nil {
+		closeStore()
+		t.Fatal(err)
+	}
+	return store, closeStore
+}
+
+func TestMemoryStoreRollback(t *testing.T) {
+
+	store := NewMemoryStore()
+
+	err := store.Transactional(func(tx StoreTx) error {
+		return tx.FundPlayer("P1", 300)
+	})
+	assert.Nil(t, err, "Fund 300 points for P1")
+
+	// Failed transaction must not change anything
+	err = store.Transactional(func(tx StoreTx) error {
+		tx.FundPlayer("P1", 100)
+		tx.FundPlayer("P2", 100)
+		return errors.New("rollback")
+	})
+	assert.EqualError(t, err, "rollback")
+
+	store.Transactional(func(tx StoreTx) error {
+		player, err := tx.Player("P1")
+		assert.Nil(t, err)
+		assert.Equal(t, int64(300), player.Balance, "P1 balance after rollback")
+
+		_, err = tx.Player("P2")
+		assert.Equal(t, sql.ErrNoRows, err, "P2 must not exist after rollback")
+		return nil
+	})
+}
+
+func TestPostgresLedgerNulls(t *testing.T) {
+
+	store, db, done := newMigrationsTestStore(t)
+	defer done()
+	assert.NoError(t, store.Initialize())
+
+	// Movement outside tournament has no tournament and game
+	err := store.Transactional(func(tx StoreTx) error {
+		return tx.InsertMovement(newMovement(MovementFund, externalAccount, playerAccount("P1"), 100))
+	})
+	assert.NoError(t, err)
+
+	var count int
+	err = db.NewQuery(`SELECT COUNT(*) FROM ledger WHERE tournament_id IS NULL AND game_id IS NULL`).Row(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count, "Entries with NULL tournament and game")
+}
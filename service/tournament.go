@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Structure for player or backer of tournament participant.
+// Payout of player closed before results is held in suspense account.
+type ParticipantShare struct {
+	PlayerID       string `json:"playerId"`
+	Contribution   int64  `json:"contribution"`
+	Payout         *int64 `json:"payout,omitempty"`
+	HeldInSuspense bool   `json:"heldInSuspense,omitempty"`
+}
+
+// Structure for player joined tournament with his backers
+type Participant struct {
+	ParticipantShare
+	Backers []ParticipantShare `json:"backers"`
+}
+
+// Structure for tournament details response.
+// Payouts are set only when tournament is finished.
+type TournamentDetails struct {
+	ID           string        `json:"tournamentId"`
+	Deposit      int64         `json:"deposit"`
+	Status       string        `json:"status"`
+	SplitPolicy  string        `json:"splitPolicy"`
+	Pool         int64         `json:"pool"`
+	Participants []Participant `json:"participants"`
+}
+
+// Method for get tournament with participants, contributions and payouts
+func (service *Service) TournamentDetails(id string) (TournamentDetails, error) {
+	var details TournamentDetails
+
+	err := service.store.Transactional(func(tx StoreTx) error {
+		tournament, err := tx.Tournament(id)
+		if err == sql.ErrNoRows {
+			return ErrTournamentNotFound
+		}
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id)
+			return err
+		}
+
+		games, err := tx.Games(id)
+		if err != nil {
+			service.Logger.Error("database error", "error", err, "tournamentId", id)
+			return err
+		}
+
+		// Prizes paid to player and suspense accounts, by game
+		var payouts map[int64][]LedgerEntry
+		if tournament.Status == StatusFinished {
+			entries, err := tx.TournamentLedger(id)
+			if err != nil {
+				service.Logger.Error("database error", "error", err, "tournamentId", id)
+				return err
+			}
+			payouts = prizePayouts(entries)
+		}
+
+		details = TournamentDetails{
+			ID:           tournament.ID,
+			Deposit:      tournament.Deposit,
+			Status:       tournament.Status,
+			SplitPolicy:  tournament.SplitPolicy,
+			Participants: []Participant{},
+		}
+
+		for _, game := range games {
+			paid, house := gameContributions(tournament, game)
+			details.Pool += house
+
+			credits := payouts[game.ID]
+			share := func(i int, p string) (ParticipantShare, error) {
+				details.Pool += paid[i]
+				s := ParticipantShare{PlayerID: p, Contribution: paid[i]}
+				if payouts == nil {
+					return s, nil
+				}
+
+				// Prizes are paid in order of participants, participant
+				// without prize has no credit
+				var payout int64
+				s.Payout = &payout
+				if len(credits) == 0 {
+					return s, nil
+				}
+				credit := credits[0]
+				if credit.Account == suspenseAccount {
+					// Only closed player's prize goes to suspense
+					player, err := tx.Player(p)
+					if err != nil && err != sql.ErrNoRows {
+						return s, err
+					}
+					s.HeldInSuspense = err == nil && player.Status == PlayerClosed
+				}
+				if credit.Account == playerAccount(p) || s.HeldInSuspense {
+					payout = credit.Amount
+					credits = credits[1:]
+				}
+				return s, nil
+			}
+
+			player, err := share(0, game.PlayerID)
+			if err != nil {
+				service.Logger.Error("database error", "error", err, "tournamentId", id)
+				return err
+			}
+			participant := Participant{
+				ParticipantShare: player,
+				Backers:          []ParticipantShare{},
+			}
+			for i, backer := range game.Backers {
+				s, err := share(i+1, backer)
+				if err != nil {
+					service.Logger.Error("database error", "error", err, "tournamentId", id)
+					return err
+				}
+				participant.Backers = append(participant.Backers, s)
+			}
+			details.Participants = append(details.Participants, participant)
+		}
+
+		return nil
+	})
+
+	return details, err
+}
+
+// Credits of prizes by game, in order they were paid: player, then backers
+func prizePayouts(entries []LedgerEntry) map[int64][]LedgerEntry {
+	payouts := map[int64][]LedgerEntry{}
+	for _, entry := range entries {
+		if entry.Kind != MovementPrize || entry.Amount <= 0 {
+			continue
+		}
+		payouts[entry.GameID] = append(payouts[entry.GameID], entry)
+	}
+	return payouts
+}
+
+// Page size of tournaments list
+const (
+	defaultTournamentsLimit = 50
+	maxTournamentsLimit     = 100
+)
+
+// Position in tournaments list, tournaments are ordered by creation time and id
+type TournamentCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// Structure for tournaments list filter, empty fields don't filter
+type TournamentFilter struct {
+	Statuses    []string
+	MinDeposit  *int64
+	MaxDeposit  *int64
+	CreatedFrom time.Time // inclusive
+	CreatedTo   time.Time // exclusive
+	PlayerID    string    // joined as player or backer
+	After       *TournamentCursor
+	Limit       int
+}
+
+// Check if tournament matches filter, except PlayerID which needs games
+func (filter TournamentFilter) Match(tournament Tournaments) bool {
+	if len(filter.Statuses) > 0 {
+		found := false
+		for _, status := range filter.Statuses {
+			found = found || status == tournament.Status
+		}
+		if !found {
+			return false
+		}
+	}
+	if filter.MinDeposit != nil && tournament.Deposit < *filter.MinDeposit {
+		return false
+	}
+	if filter.MaxDeposit != nil && tournament.Deposit > *filter.MaxDeposit {
+		return false
+	}
+	if !filter.CreatedFrom.IsZero() && tournament.CreatedAt.Before(filter.CreatedFrom) {
+		return false
+	}
+	if !filter.CreatedTo.IsZero() && !tournament.CreatedAt.Before(filter.CreatedTo) {
+		return false
+	}
+	if filter.After != nil {
+		after := Tournaments{ID: filter.After.ID, CreatedAt: filter.After.CreatedAt}
+		if !tournamentBefore(after, tournament) {
+			return false
+		}
+	}
+	return true
+}
+
+// Order of tournaments in list
+func tournamentBefore(a Tournaments, b Tournaments) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+// Encode cursor pointing after tournament, cursor is opaque for clients
+func encodeTournamentCursor(tournament Tournaments) string {
+	value := tournament.CreatedAt.UTC().Format(time.RFC3339Nano) + " " + tournament.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(value))
+}
+
+// Decode cursor returned in previous page
+func decodeTournamentCursor(cursor string) (*TournamentCursor, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(string(value), " ", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid cursor")
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return &TournamentCursor{CreatedAt: createdAt, ID: parts[1]}, nil
+}
+
+// Structure for page of tournaments list, NextCursor is empty on the last page
+type TournamentsPage struct {
+	Tournaments []Tournaments `json:"tournaments"`
+	NextCursor  string        `json:"nextCursor,omitempty"`
+}
+
+// Method for list tournaments matching filter, one page at a time
+func (service *Service) ListTournaments(filter TournamentFilter) (TournamentsPage, error) {
+	if filter.Limit <= 0 || filter.Limit > maxTournamentsLimit {
+		filter.Limit = defaultTournamentsLimit
+	}
+	limit := filter.Limit
+
+	// Load one more tournament to know if there is next page
+	filter.Limit++
+
+	var tournaments []Tournaments
+	err := service.store.Transactional(func(tx StoreTx) error {
+		var err error
+		tournaments, err = tx.ListTournaments(filter)
+		return err
+	})
+	if err != nil {
+		service.Logger.Error("database error", "error", err)
+		return TournamentsPage{}, err
+	}
+
+	page := TournamentsPage{Tournaments: tournaments}
+	if len(tournaments) > limit {
+		page.Tournaments = tournaments[:limit]
+		page.NextCursor = encodeTournamentCursor(tournaments[limit-1])
+	}
+	if page.Tournaments == nil {
+		page.Tournaments = []Tournaments{}
+	}
+	return page, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTournamentDetails(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	details := func() TournamentDetails {
+		var details TournamentDetails
+		res := get("/tournament?tournamentId=1")
+		defer res.Body.Close()
+		assert.Equal(t, 200, res.StatusCode, "Tournament details")
+		json.NewDecoder(res.Body).Decode(&details)
+		return details
+	}
+
+	for _, p := range []string{"P1", "P2", "P3"} {
+		get(fmt.Sprintf("/fund?playerId=%s&points=1000", p))
+	}
+	get("/announceTournament?tournamentId=1&deposit=1000")
+	get("/joinTournament?tournamentId=1&playerId=P1&backerId=P2&playerStake=600&stake=400")
+	get("/joinTournament?tournamentId=1&playerId=P3")
+
+	d := details()
+	assert.Equal(t, StatusRegistrationOpen, d.Status)
+	assert.Equal(t, int64(2000), d.Pool)
+	if assert.Len(t, d.Participants, 2) {
+		p1 := d.Participants[0]
+		assert.Equal(t, "P1", p1.PlayerID)
+		assert.Equal(t, int64(600), p1.Contribution)
+		assert.Nil(t, p1.Payout, "No payouts before results")
+		assert.Equal(t, []ParticipantShare{{PlayerID: "P2", Contribution: 400}}, p1.Backers)
+		assert.Equal(t, "P3", d.Participants[1].PlayerID)
+		assert.Empty(t, d.Participants[1].Backers)
+	}
+
+	body := `{"tournamentId": "1", "winners": [{"playerId": "P1", "prize": 2000}]}`
+	res, err := http.Post(server.URL+"/resultTournament", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	d = details()
+	assert.Equal(t, StatusFinished, d.Status)
+	if assert.Len(t, d.Participants, 2) {
+		p1 := d.Participants[0]
+		assert.Equal(t, int64(1200), *p1.Payout)
+		assert.Equal(t, int64(800), *p1.Backers[0].Payout)
+		assert.Equal(t, int64(0), *d.Participants[1].Payout)
+	}
+
+	res = get("/tournament?tournamentId=2")
+	assert.Equal(t, 400, res.StatusCode, "Tournament not found")
+}
+
+func TestTournamentDetailsSuspense(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	request := func(method string, path string, body string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, 200, res.StatusCode, method+" "+path)
+	}
+
+	for _, p := range []string{"P1", "P2", "P3"} {
+		request("GET", "/fund?playerId="+p+"&points=1000", ``)
+	}
+	request("GET", "/announceTournament?tournamentId=1&deposit=900", ``)
+	request("GET", "/joinTournament?tournamentId=1&playerId=P1&backerId=P2&backerId=P3", ``)
+
+	// Winner and one of backers close accounts before results
+	for _, p := range []string{"P1", "P3"} {
+		request("GET", "/take?playerId="+p+"&points=700", ``)
+		request("POST", "/v2/players/"+p+"/close", ``)
+	}
+	request("POST", "/resultTournament", `{"tournamentId": "1", "winners": [{"playerId": "P1", "prize": 900}]}`)
+
+	res, err := http.Get(server.URL + "/v2/tournaments/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var d TournamentDetails
+	json.NewDecoder(res.Body).Decode(&d)
+
+	// Payouts of closed players are reported as held in suspense
+	if assert.Len(t, d.Participants, 1) {
+		p1 := d.Participants[0]
+		assert.Equal(t, int64(300), *p1.Payout)
+		assert.True(t, p1.HeldInSuspense, "P1 payout is held in suspense")
+		if assert.Len(t, p1.Backers, 2) {
+			assert.Equal(t, int64(300), *p1.Backers[0].Payout)
+			assert.False(t, p1.Backers[0].HeldInSuspense, "P2 gets payout")
+			assert.Equal(t, int64(300), *p1.Backers[1].Payout)
+			assert.True(t, p1.Backers[1].HeldInSuspense, "P3 payout is held in suspense")
+		}
+	}
+}
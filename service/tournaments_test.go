@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestListTournaments(t *testing.T) {
+
+	server := httptest.NewServer(initRouter(NewMemoryStore()))
+	defer server.Close()
+
+	get := func(path string) *http.Response {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	list := func(query string) []string {
+		var ids []string
+		for cursor := ""; ; {
+			var page TournamentsPage
+			res := get("/tournaments?limit=2&cursor=" + cursor + "&" + query)
+			assert.Equal(t, 200, res.StatusCode, "List ", query)
+			json.NewDecoder(res.Body).Decode(&page)
+			res.Body.Close()
+
+			assert.True(t, len(page.Tournaments) <= 2, "Page size")
+			for _, tournament := range page.Tournaments {
+				ids = append(ids, tournament.ID)
+			}
+			if page.NextCursor == "" {
+				return ids
+			}
+			cursor = page.NextCursor
+		}
+	}
+
+	start := time.Now().Add(-time.Second)
+	get("/fund?playerId=P1&points=1000")
+	get("/fund?playerId=P2&points=1000")
+	for i := 1; i <= 5; i++ {
+		get(fmt.Sprintf("/announceTournament?tournamentId=%d&deposit=%d", i, i*100))
+	}
+	get("/joinTournament?tournamentId=2&playerId=P1")
+	get("/joinTournament?tournamentId=4&playerId=P2&backerId=P1")
+	get("/closeRegistration?tournamentId=5")
+
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, list(""))
+	assert.Equal(t, []string{"1", "2", "3", "4"}, list("status=registration_open"))
+	assert.Equal(t, []string{"2", "3", "4"}, list("minDeposit=200&maxDeposit=400"))
+	assert.Equal(t, []string{"2", "4"}, list("playerId=P1"))
+	assert.Equal(t, []string{"5"}, list("status=registration_closed&status=finished"))
+
+	from := url.QueryEscape(start.Format(time.RFC3339))
+	assert.Equal(t, []string{"1", "2", "3", "4", "5"}, list("createdFrom="+from))
+	assert.Empty(t, list("createdTo="+from))
+
+	for _, query := range []string{"limit=0", "limit=x", "minDeposit=x", "createdFrom=today", "cursor=%21"} {
+		res := get("/tournaments?" + query)
+		assert.Equal(t, 400, res.StatusCode, "Invalid ", query)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+
+	store := NewMemoryStore()
+	server := httptest.NewServer(initRouter(store))
+	defer server.Close()
+
+	transfer := func(body string, data interface{}) int {
+		res, err := http.Post(server.URL+"/v2/transfers", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		json.NewDecoder(res.Body).Decode(data)
+		return res.StatusCode
+	}
+
+	service := Service{store: store}
+	service.Fund("P1", 300)
+	service.Fund("P2", 100)
+
+	var response TransferResponse
+	status := transfer(`{"fromPlayerId": "P1", "toPlayerId": "P2", "points": 200}`, &response)
+	assert.Equal(t, 200, status, "P1 transfers 200 to P2")
+	assert.Equal(t, Players{ID: "P1", Balance: 100, Status: PlayerActive}, response.From)
+	assert.Equal(t, Players{ID: "P2", Balance: 300, Status: PlayerActive}, response.To)
+
+	cases := []struct {
+		body string
+		code string
+	}{
+		{`{"fromPlayerId": "P1", "toPlayerId": "P2", "points": 101}`, "insufficient_funds"},
+		{`{"fromPlayerId": "P1", "toPlayerId": "P3", "points": 50}`, "player_not_found"},
+		{`{"fromPlayerId": "P3", "toPlayerId": "P1", "points": 50}`, "player_not_found"},
+		{`{"fromPlayerId": "P2", "toPlayerId": "P2", "points": 50}`, "same_transfer_player"},
+	}
+	for _, c := range cases {
+		var apiError APIError
+		status := transfer(c.body, &apiError)
+		assert.Equal(t, 400, status, "Status for "+c.body)
+		assert.Equal(t, c.code, apiError.Code, "Code for "+c.body)
+	}
+
+	// Failed transfers change nothing, transfer is one movement with debit and credit
+	for id, balance := range map[string]int64{"P1": 100, "P2": 300} {
+		player, _ := service.PlayerBalance(id)
+		assert.Equal(t, balance, player.Balance, "Balance of "+id)
+	}
+	var transfers []LedgerEntry
+	for _, entry := range store.state.ledger {
+		if entry.Kind == MovementTransfer {
+			transfers = append(transfers, entry)
+		}
+	}
+	if assert.Len(t, transfers, 2) {
+		assert.Equal(t, transfers[0].MovementID, transfers[1].MovementID, "Entries are linked")
+		assert.Equal(t, int64(-200), transfers[0].Amount)
+		assert.Equal(t, int64(200), transfers[1].Amount)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/go-ozzo/ozzo-routing"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Middleware for validate requests against OpenAPI document.
+// Requests of routes missing in document are passed as is,
+// router responds 404 or 405 for them.
+func validationHandler(spec *OpenAPI) routing.Handler {
+	return func(c *routing.Context) error {
+		op, params := spec.find(c.Request.Method, c.Request.URL.Path)
+		if op == nil {
+			return nil
+		}
+
+		for _, param := range op.Parameters {
+			var values []string
+			switch param.In {
+			case "query":
+				values = c.Request.URL.Query()[param.Name]
+			case "path":
+				values = []string{params[param.Name]}
+			case "header":
+				values = c.Request.Header[param.Name]
+			}
+
+			if len(values) == 0 || (len(values) == 1 && values[0] == "") {
+				if param.Required {
+					return badRequest(param.Name + " is requred")
+				}
+				continue
+			}
+
+			schema := spec.resolve(param.Schema)
+			if schema.Type == "array" {
+				schema = spec.resolve(schema.Items)
+			} else {
+				values = values[:1]
+			}
+			for _, value := range values {
+				if err := spec.validateParam(schema, value); err != nil {
+					return badRequest(fmt.Sprintf("invalid %s: %v", param.Name, err))
+				}
+			}
+		}
+
+		if op.RequestBody == nil {
+			return nil
+		}
+
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			return err
+		}
+		// Body must be readable by controller again
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		var data interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&data); err != nil {
+			return badRequest("invalid JSON body")
+		}
+
+		schema := op.RequestBody.Content["application/json"].Schema
+		if err := spec.validateJSON(schema, data, "body"); err != nil {
+			return badRequest(err.Error())
+		}
+		return nil
+	}
+}
+
+// Find operation for request, path params are returned by name
+func (spec *OpenAPI) find(method string, path string) (*Operation, map[string]string) {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	for template, item := range spec.Paths {
+		op := item[strings.ToLower(method)]
+		if op == nil {
+			continue
+		}
+
+		parts := strings.Split(strings.Trim(template, "/"), "/")
+		if len(parts) != len(segments) {
+			continue
+		}
+
+		params := map[string]string{}
+		for i, part := range parts {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				params[strings.Trim(part, "{}")] = segments[i]
+			} else if part != segments[i] {
+				params = nil
+				break
+			}
+		}
+		if params != nil {
+			return op, params
+		}
+	}
+
+	return nil, nil
+}
+
+// Resolve reference to component schema
+func (spec *OpenAPI) resolve(schema *Schema) *Schema {
+	for schema.Ref != "" {
+		schema = spec.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	return schema
+}
+
+// Validate query, path or header param value
+func (spec *OpenAPI) validateParam(schema *Schema, value string) error {
+	switch schema.Type {
+	case "integer":
+		number, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("must be integer")
+		}
+		return validateRange(schema, number)
+	case "boolean":
+		if value != "true" && value != "false" {
+			return fmt.Errorf("must be true or false")
+		}
+	case "string":
+		return validateString(schema, value)
+	}
+	return nil
+}
+
+// Validate decoded JSON value, path is used in error messages
+func (spec *OpenAPI) validateJSON(schema *Schema, value interface{}, path string) error {
+	schema = spec.resolve(schema)
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be object", path)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s.%s is requred", path, name)
+			}
+		}
+		for name, property := range schema.Properties {
+			if v, ok := object[name]; ok {
+				if err := spec.validateJSON(property, v, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s must be array", path)
+		}
+		for i, item := range array {
+			if err := spec.validateJSON(schema.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		if !ok {
+			return fmt.Errorf("%s must be integer", path)
+		}
+		n, err := number.Int64()
+		if err != nil {
+			return fmt.Errorf("%s must be integer", path)
+		}
+		if err := validateRange(schema, n); err != nil {
+			return fmt.Errorf("%s %v", path, err)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s must be boolean", path)
+		}
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%s must be string", path)
+		}
+		if err := validateString(schema, s); err != nil {
+			return fmt.Errorf("%s %v", path, err)
+		}
+	}
+	return nil
+}
+
+// Check integer minimum and maximum
+func validateRange(schema *Schema, value int64) error {
+	if schema.Minimum != nil && value < *schema.Minimum {
+		return fmt.Errorf("must be at least %d", *schema.Minimum)
+	}
+	if schema.Maximum != nil && value > *schema.Maximum {
+		return fmt.Errorf("must be at most %d", *schema.Maximum)
+	}
+	return nil
+}
+
+// Check string enum and format
+func validateString(schema *Schema, value string) error {
+	if len(schema.Enum) > 0 {
+		found := false
+		for _, v := range schema.Enum {
+			found = found || v == value
+		}
+		if !found {
+			return fmt.Errorf("must be one of %s", strings.Join(schema.Enum, ", "))
+		}
+	}
+	if schema.Format == "date-time" {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("must be RFC 3339 time")
+		}
+	}
+	return nil
+}